@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/psanford/lambda-reminder/config"
+	"github.com/psanford/lambda-reminder/scheduler"
+	"github.com/psanford/lambda-reminder/state"
+)
+
+// AdminRequest is a manual invoke payload for one-off operations, e.g.
+//
+//	aws lambda invoke --function-name reminder \
+//	  --cli-binary-format raw-in-base64-out \
+//	  --payload '{"action":"skip","rule":"standup","count":2}' out.json
+type AdminRequest struct {
+	Action string `json:"action"`
+	// Rule is the rule name or id. It is required for fire_rule, skip,
+	// snooze and reset_state, optional for preview_next and ignored by
+	// dry_run.
+	Rule string `json:"rule,omitempty"`
+	// Occurrence selects the occurrence to snooze, 0 means the pending one.
+	Occurrence int `json:"occurrence,omitempty"`
+	// Count is the number of occurrences to skip (default 1) or to preview
+	// (default 5).
+	Count *int `json:"count,omitempty"`
+	// Until is an RFC 3339 time or a duration from now to snooze until.
+	Until string `json:"until,omitempty"`
+	// Now overrides the current time for dry_run, RFC 3339.
+	Now string `json:"now,omitempty"`
+}
+
+type AdminResponse struct {
+	Action string `json:"action"`
+	Rule   string `json:"rule,omitempty"`
+	Status string `json:"status"`
+
+	// DryRun is what a dry run would have done.
+	DryRun *runResult `json:"dry_run,omitempty"`
+	// Preview holds upcoming run times for preview_next.
+	Preview []rulePreview `json:"preview,omitempty"`
+}
+
+type rulePreview struct {
+	Rule   string `json:"rule"`
+	Active bool   `json:"active"`
+	// NextRunTime is the next run time stored in state, which can differ
+	// from the first upcoming time if the rule is overdue.
+	NextRunTime   time.Time   `json:"next_run_time,omitzero"`
+	SkipRemaining int         `json:"skip_remaining,omitempty"`
+	SnoozedUntil  time.Time   `json:"snoozed_until,omitzero"`
+	Upcoming      []time.Time `json:"upcoming"`
+	Error         string      `json:"error,omitempty"`
+}
+
+const (
+	AdminActionFireRule    = "fire_rule"
+	AdminActionDryRun      = "dry_run"
+	AdminActionSkip        = "skip"
+	AdminActionSnooze      = "snooze"
+	AdminActionResetState  = "reset_state"
+	AdminActionPreviewNext = "preview_next"
+
+	defaultPreviewCount = 5
+	maxPreviewCount     = 100
+)
+
+func (h *handler) handleAdmin(ctx context.Context, req AdminRequest) (*AdminResponse, error) {
+	h.lgr.Info("processing admin request", "action", req.Action, "rule", req.Rule)
+
+	resp := &AdminResponse{
+		Action: req.Action,
+		Rule:   req.Rule,
+		Status: "ok",
+	}
+
+	var err error
+	switch req.Action {
+	case AdminActionFireRule:
+		err = h.fireCommand(ctx, req.Rule)
+	case AdminActionDryRun:
+		var opts runOptions
+		opts.DryRun = true
+		if req.Now != "" {
+			opts.Now, err = time.Parse(time.RFC3339, req.Now)
+			if err != nil {
+				return nil, fmt.Errorf("invalid now, expected RFC 3339: %w", err)
+			}
+		}
+		resp.DryRun, err = h.run(ctx, opts)
+	case AdminActionSkip:
+		err = h.skipCommand(ctx, req.Rule, countOr(req.Count, 1))
+	case AdminActionSnooze:
+		err = h.snoozeCommand(ctx, req.Rule, req.Occurrence, req.Until)
+	case AdminActionResetState:
+		err = h.updateRuleState(ctx, req.Rule, func(sched *scheduler.Scheduler, conf *config.Config, st *state.State, rule config.Rule, now time.Time) error {
+			h.lgr.Info("resetting rule state", "rule", rule.Name)
+			st.ResetRule(rule.StateKey())
+			return nil
+		})
+	case AdminActionPreviewNext:
+		resp.Preview, err = h.previewNext(ctx, req.Rule, countOr(req.Count, defaultPreviewCount))
+	default:
+		return nil, fmt.Errorf("unknown admin action: %q", req.Action)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", req.Action, err)
+	}
+
+	return resp, nil
+}
+
+func countOr(count *int, def int) int {
+	if count == nil {
+		return def
+	}
+	return *count
+}
+
+// previewNext returns the next count run times for the named rule, or for
+// every rule if name is empty. State is read but not modified.
+func (h *handler) previewNext(ctx context.Context, name string, count int) ([]rulePreview, error) {
+	if count < 1 || count > maxPreviewCount {
+		return nil, fmt.Errorf("count must be between 1 and %d", maxPreviewCount)
+	}
+
+	conf, st, err := h.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := conf.Rules
+	if name != "" {
+		rule, ok := conf.FindRule(name)
+		if !ok {
+			return nil, fmt.Errorf("rule %s not found", name)
+		}
+		rules = []config.Rule{rule}
+	}
+
+	now, err := h.configNow(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	sched := scheduler.New(h.lgr)
+
+	var previews []rulePreview
+	for _, rule := range rules {
+		ruleState := st.Rules[rule.StateKey()]
+		preview := rulePreview{
+			Rule:          rule.Name,
+			Active:        rule.ActiveAt(now),
+			NextRunTime:   ruleState.NextRunTime,
+			SkipRemaining: ruleState.SkipRemaining,
+			SnoozedUntil:  ruleState.SnoozedUntil,
+		}
+
+		cursor := now
+		for range count {
+			next, err := sched.GetRuleNextRunTime(rule, cursor)
+			if errors.Is(err, scheduler.ErrNoMoreOccurrences) {
+				break
+			}
+			if err != nil {
+				preview.Error = err.Error()
+				break
+			}
+			preview.Upcoming = append(preview.Upcoming, next)
+			cursor = next
+		}
+
+		previews = append(previews, preview)
+	}
+
+	return previews, nil
+}
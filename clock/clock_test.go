@@ -0,0 +1,65 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+
+	if !f.Now().Equal(start) {
+		t.Fatalf("Now() = %v, want %v", f.Now(), start)
+	}
+
+	short := f.After(time.Minute)
+	long := f.After(time.Hour)
+
+	done := make(chan struct{})
+	go func() {
+		f.BlockUntil(2)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("BlockUntil didn't return with 2 waiters")
+	}
+
+	f.Advance(30 * time.Second)
+	select {
+	case <-short:
+		t.Fatal("Expected short timer not to fire before its deadline")
+	default:
+	}
+
+	f.Advance(30 * time.Second)
+	select {
+	case got := <-short:
+		if want := start.Add(time.Minute); !got.Equal(want) {
+			t.Errorf("short fired with %v, want %v", got, want)
+		}
+	default:
+		t.Fatal("Expected short timer to fire")
+	}
+
+	select {
+	case <-long:
+		t.Fatal("Expected long timer not to fire yet")
+	default:
+	}
+
+	f.Set(start.Add(2 * time.Hour))
+	select {
+	case <-long:
+	default:
+		t.Fatal("Expected long timer to fire after Set")
+	}
+
+	select {
+	case <-f.After(0):
+	default:
+		t.Fatal("Expected After(0) to fire immediately")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/psanford/lambda-reminder/config"
+	"github.com/psanford/lambda-reminder/scheduler"
+	"github.com/psanford/lambda-reminder/state"
+)
+
+// fireCommand sends a new occurrence of a rule now, regardless of its
+// schedule, and records it in state like a scheduled run.
+func (h *handler) fireCommand(ctx context.Context, name string) error {
+	if name == "" {
+		return fmt.Errorf("-rule is required")
+	}
+
+	conf, st, err := h.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	rule, ok := conf.FindRule(name)
+	if !ok {
+		return fmt.Errorf("rule %s not found", name)
+	}
+
+	now, err := h.configNow(conf)
+	if err != nil {
+		return err
+	}
+
+	before, err := st.Clone()
+	if err != nil {
+		return fmt.Errorf("copy state: %w", err)
+	}
+
+	sched := scheduler.New(h.lgr)
+
+	h.lgr.Info("manually firing rule", "rule", rule.Name)
+	err = h.fireRule(ctx, h.sender, sched, conf, st, rule, now)
+	if err != nil {
+		return err
+	}
+
+	return h.saveState(ctx, before, st)
+}
+
+// ackCommand acknowledges a rule's pending occurrence, stopping repeats.
+func (h *handler) ackCommand(ctx context.Context, name string, occurrence int) error {
+	return h.updateRuleState(ctx, name, func(sched *scheduler.Scheduler, conf *config.Config, st *state.State, rule config.Rule, now time.Time) error {
+		return sched.Acknowledge(st, rule, occurrence, now)
+	})
+}
+
+// skipCommand skips a rule's next count occurrences.
+func (h *handler) skipCommand(ctx context.Context, name string, count int) error {
+	return h.updateRuleState(ctx, name, func(sched *scheduler.Scheduler, conf *config.Config, st *state.State, rule config.Rule, now time.Time) error {
+		return sched.Skip(st, rule, count)
+	})
+}
+
+// snoozeCommand snoozes a rule's pending occurrence, or its next one if
+// nothing is waiting for a repeat or acknowledgement. until is an RFC 3339
+// time or a duration from now such as "2h" or "3d".
+func (h *handler) snoozeCommand(ctx context.Context, name string, occurrence int, until string) error {
+	return h.updateRuleState(ctx, name, func(sched *scheduler.Scheduler, conf *config.Config, st *state.State, rule config.Rule, now time.Time) error {
+		t, err := parseUntil(until, now)
+		if err != nil {
+			return err
+		}
+		return sched.Snooze(conf, st, rule, occurrence, t)
+	})
+}
+
+// updateRuleState loads config and state, applies fn to the named rule and
+// saves the result. fn is applied again if the state has to be reloaded.
+func (h *handler) updateRuleState(ctx context.Context, name string, fn func(sched *scheduler.Scheduler, conf *config.Config, st *state.State, rule config.Rule, now time.Time) error) error {
+	if name == "" {
+		return fmt.Errorf("-rule is required")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.updateState(ctx, func(conf *config.Config, st *state.State) error {
+		rule, ok := conf.FindRule(name)
+		if !ok {
+			return fmt.Errorf("rule %s not found", name)
+		}
+
+		now, err := h.configNow(conf)
+		if err != nil {
+			return err
+		}
+
+		return fn(scheduler.New(h.lgr), conf, st, rule, now)
+	})
+}
+
+func parseUntil(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, fmt.Errorf("snooze until is required")
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	var d config.Duration
+	err := d.UnmarshalText([]byte(s))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid snooze until %q, expected an RFC 3339 time or a duration", s)
+	}
+	if d.Duration <= 0 {
+		return time.Time{}, fmt.Errorf("snooze duration must be positive")
+	}
+
+	return now.Add(d.Duration), nil
+}
+
+// convertCommand loads and validates the config and writes it to out in
+// another format. A config split across several files is written as one.
+// References are written as they are rather than resolved.
+func (h *handler) convertCommand(ctx context.Context, stdout io.Writer, out, outFormat string) error {
+	format, err := config.ParseFormat(outFormat)
+	if err != nil {
+		return err
+	}
+	if format == "" {
+		f, ok := config.FormatFromPath(out)
+		if !ok {
+			return fmt.Errorf("-out_format is required unless -out has a .toml, .yaml, .yml or .json extension")
+		}
+		format = f
+	}
+
+	loader := *h.configLoader()
+	loader.Resolvers = nil
+	conf, err := loader.LoadConfig(ctx, h.s3Client, h.lgr, *configPath)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	if out == "" {
+		return config.Encode(stdout, conf, format)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", out, err)
+	}
+	err = config.Encode(f, conf, format)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("encode config: %w", err)
+	}
+	return f.Close()
+}
+
+// schemaCommand writes the config JSON Schema to out, or stdout if out is
+// empty.
+func schemaCommand(stdout io.Writer, out string) error {
+	if out == "" {
+		return encodeSchema(stdout)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", out, err)
+	}
+	err = encodeSchema(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("encode schema: %w", err)
+	}
+	return f.Close()
+}
+
+func encodeSchema(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(config.Schema())
+}
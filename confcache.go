@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/psanford/lambda-reminder/config"
+	"github.com/psanford/lambda-reminder/notifications"
+)
+
+// secretRefreshInterval is how often a cached config that uses references
+// is read in full, so rotated secrets are picked up without the config
+// itself changing.
+const secretRefreshInterval = 15 * time.Minute
+
+// configLoader returns the loader to load the config with.
+func (h *handler) configLoader() *config.Loader {
+	if h.loader == nil {
+		return config.NewLoader()
+	}
+	return h.loader
+}
+
+// loadConfig returns the config for a run. The last good config is cached
+// on the handler, so warm Lambda invocations only revalidate it with a
+// conditional read, and a watched local file is only re-read when it
+// changes. A config with resolved secrets is also re-read every
+// secretRefreshInterval. A new config that fails to load is reported
+// once, to the cached config's error destinations, and the cached config
+// stays in use.
+func (h *handler) loadConfig(ctx context.Context) (*config.Config, error) {
+	h.confMu.Lock()
+	conf, rejected, err := h.loadConfigLocked(ctx)
+	h.confMu.Unlock()
+
+	// Notify without holding the lock, so a slow send doesn't hold up
+	// other runs and link requests waiting on the config
+	if rejected != nil {
+		h.notifyConfigError(ctx, conf, rejected)
+	}
+	return conf, err
+}
+
+// loadConfigLocked is loadConfig with confMu held. rejected is set to the
+// error of a new config that should be reported to conf's error
+// destinations.
+func (h *handler) loadConfigLocked(ctx context.Context) (conf *config.Config, rejected, err error) {
+	now := h.clock.Now()
+	refresh := h.conf != nil && len(h.conf.Secrets()) > 0 && now.Sub(h.confReadAt) >= secretRefreshInterval
+
+	if h.watchingConfig && h.conf != nil && !refresh {
+		return h.conf, nil, nil
+	}
+
+	tag := h.confTag
+	if refresh {
+		tag = ""
+	}
+
+	conf, tag, err = h.configLoader().LoadConfigIfModified(ctx, h.s3Client, h.lgr, *configPath, tag)
+	switch {
+	case errors.Is(err, config.ErrNotModified):
+		if h.conf == nil {
+			return nil, nil, h.confErr
+		}
+		return h.conf, nil, nil
+	case err != nil && tag == "":
+		// The config couldn't be fetched at all, which is more likely a
+		// transient S3 problem than a bad config
+		if h.conf == nil {
+			return nil, nil, fmt.Errorf("load config: %w", err)
+		}
+		h.lgr.Warn("failed to fetch config, using cached config", "err", err)
+		return h.conf, nil, nil
+	case err != nil:
+		h.confTag, h.confReadAt = tag, now
+		h.confErr = fmt.Errorf("load config: %w", err)
+		if h.conf == nil {
+			return nil, nil, h.confErr
+		}
+		h.lgr.Error("new config failed to load, keeping last good config", "err", err)
+		return h.conf, err, nil
+	}
+
+	if h.conf != nil {
+		if d := config.DiffConfigs(h.conf, conf); !d.Empty() {
+			h.lgr.Info("config changed",
+				"added", d.Added,
+				"removed", d.Removed,
+				"changed", d.Changed,
+				"settings_changed", d.Settings)
+		}
+	}
+
+	h.secrets.Add(conf.Secrets()...)
+	h.conf, h.confTag, h.confErr, h.confReadAt = conf, tag, nil, now
+	return conf, nil, nil
+}
+
+// reloadConfig loads and validates the config, replacing the cached one
+// only if it is valid, and wakes the run loop when anything changed.
+func (h *handler) reloadConfig(ctx context.Context) error {
+	conf, err := h.configLoader().LoadConfig(ctx, h.s3Client, h.lgr, *configPath)
+	if err != nil {
+		h.lgr.Error("config reload failed, keeping last known-good config", "err", err)
+		h.confMu.Lock()
+		last := h.conf
+		h.confMu.Unlock()
+		if last != nil {
+			h.notifyConfigError(ctx, last, err)
+		}
+		return err
+	}
+
+	h.confMu.Lock()
+	defer h.confMu.Unlock()
+
+	if h.conf == nil {
+		h.lgr.Info("config loaded", "rules", len(conf.Rules))
+	} else {
+		d := config.DiffConfigs(h.conf, conf)
+		if d.Empty() {
+			h.lgr.Debug("config reloaded without changes")
+			return nil
+		}
+		h.lgr.Info("config reloaded",
+			"added", d.Added,
+			"removed", d.Removed,
+			"changed", d.Changed,
+			"settings_changed", d.Settings)
+	}
+
+	h.secrets.Add(conf.Secrets()...)
+	h.conf, h.confReadAt = conf, h.clock.Now()
+
+	select {
+	case h.configReloaded <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// notifyConfigError tells conf's error destinations that a new config was
+// rejected. Failures are only logged.
+func (h *handler) notifyConfigError(ctx context.Context, conf *config.Config, loadErr error) {
+	if len(conf.ErrorDestinations) == 0 {
+		return
+	}
+
+	dests := h.sender.GetDestinationsForRule(config.Rule{
+		Name:         "error_destinations",
+		Destinations: conf.ErrorDestinations,
+	}, conf.Destinations)
+
+	msg := notifications.Message{
+		RuleName: "config",
+		Subject:  "Reminder config failed to load",
+		Body:     fmt.Sprintf("A new config failed to load and was ignored. Reminders are still running with the last good config.\n\n%s", loadErr),
+	}
+
+	err := h.sender.SendMessage(ctx, msg, dests)
+	if err != nil {
+		h.lgr.Error("send config error notification", "err", err)
+	}
+}
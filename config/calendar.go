@@ -0,0 +1,196 @@
+package config
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Calendar is a named set of blackout dates, such as company holidays.
+type Calendar struct {
+	Name string `toml:"name,omitempty" json:"name,omitempty" yaml:"name,omitempty"`
+	// Dates lists dates as "2006-01-02".
+	Dates []string `toml:"dates,omitempty" json:"dates,omitempty" yaml:"dates,omitempty"`
+	// File is an iCalendar file whose events' dates are added to the
+	// calendar. It is either a local path or an s3://bucket/key URL.
+	File string `toml:"file,omitempty" json:"file,omitempty" yaml:"file,omitempty"`
+
+	days map[string]bool
+	loc  location
+}
+
+// Contains reports whether t's date, in t's location, is in the calendar.
+func (c *Calendar) Contains(t time.Time) bool {
+	return c.days[t.Format(dateFormat)]
+}
+
+func (c *Calendar) addDay(day string) {
+	if c.days == nil {
+		c.days = make(map[string]bool)
+	}
+	c.days[day] = true
+}
+
+const (
+	CalendarPolicySkip                = "skip"
+	CalendarPolicyNextBusinessDay     = "next_business_day"
+	CalendarPolicyPreviousBusinessDay = "previous_business_day"
+)
+
+// ResolveCalendars parses each calendar's dates and links rules to the
+// calendars named in their skip_calendars. LoadConfig calls it after
+// validation; configs built in code must call it before scheduling.
+func (c *Config) ResolveCalendars() error {
+	byName := make(map[string]*Calendar)
+	for i := range c.Calendars {
+		cal := &c.Calendars[i]
+		for _, d := range cal.Dates {
+			day, err := time.Parse(dateFormat, d)
+			if err != nil {
+				return fmt.Errorf("calendar %s: invalid date %q, expected YYYY-MM-DD", cal.Name, d)
+			}
+			cal.addDay(day.Format(dateFormat))
+		}
+		byName[cal.Name] = cal
+	}
+
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		rule.calendars = nil
+		for _, name := range rule.SkipCalendars {
+			cal, ok := byName[name]
+			if !ok {
+				return fmt.Errorf("rule %s: calendar %s not found", rule.Name, name)
+			}
+			rule.calendars = append(rule.calendars, cal)
+		}
+	}
+
+	return nil
+}
+
+func loadCalendarFiles(ctx context.Context, s3Client *s3.Client, conf *Config) error {
+	for i := range conf.Calendars {
+		cal := &conf.Calendars[i]
+		if cal.File == "" {
+			continue
+		}
+
+		var r io.ReadCloser
+		if bucket, key, ok := parseS3URL(cal.File); ok {
+			resp, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+				Bucket: &bucket,
+				Key:    &key,
+			})
+			if err != nil {
+				return fmt.Errorf("calendar %s: get %s from s3: %w", cal.Name, cal.File, err)
+			}
+			r = resp.Body
+		} else {
+			f, err := os.Open(cal.File)
+			if err != nil {
+				return fmt.Errorf("calendar %s: open %s: %w", cal.Name, cal.File, err)
+			}
+			r = f
+		}
+
+		days, err := parseICalDates(r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("calendar %s: parse %s: %w", cal.Name, cal.File, err)
+		}
+
+		for _, day := range days {
+			cal.addDay(day)
+		}
+	}
+
+	return nil
+}
+
+func parseS3URL(s string) (bucket, key string, ok bool) {
+	rest, ok := strings.CutPrefix(s, "s3://")
+	if !ok {
+		return "", "", false
+	}
+	bucket, key, ok = strings.Cut(rest, "/")
+	if !ok || bucket == "" || key == "" {
+		return "", "", false
+	}
+	return bucket, key, true
+}
+
+// parseICalDates returns the dates covered by the VEVENTs in an iCalendar
+// stream. All-day events cover DTSTART up to but not including DTEND; timed
+// events cover the date of DTSTART. Recurring events are not expanded.
+func parseICalDates(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// Lines starting with whitespace continue the previous line (RFC 5545 3.1)
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		days       []string
+		inEvent    bool
+		start, end time.Time
+	)
+	for _, line := range lines {
+		switch {
+		case line == "BEGIN:VEVENT":
+			inEvent = true
+			start, end = time.Time{}, time.Time{}
+		case line == "END:VEVENT":
+			inEvent = false
+			if start.IsZero() {
+				return nil, fmt.Errorf("event without DTSTART")
+			}
+			if end.IsZero() || !end.After(start) {
+				end = start.AddDate(0, 0, 1)
+			}
+			for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+				days = append(days, d.Format(dateFormat))
+			}
+		case inEvent:
+			name, value, ok := strings.Cut(line, ":")
+			if !ok {
+				continue
+			}
+			name, _, _ = strings.Cut(name, ";")
+
+			if name != "DTSTART" && name != "DTEND" {
+				continue
+			}
+			if len(value) < 8 {
+				return nil, fmt.Errorf("invalid %s value %q", name, value)
+			}
+			day, err := time.Parse("20060102", value[:8])
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q", name, value)
+			}
+			if name == "DTSTART" {
+				start = day
+			} else if len(value) == 8 {
+				// Only all-day events have an exclusive date-only DTEND
+				end = day
+			}
+		}
+	}
+
+	return days, nil
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseICalDates(t *testing.T) {
+	ics := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20241225",
+		"DTEND;VALUE=DATE:20241227",
+		"SUMMARY:Winter",
+		"  break",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20250101",
+		"SUMMARY:New Year",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART;TZID=America/New_York:20250704T120000",
+		"DTEND;TZID=America/New_York:20250704T130000",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	days, err := parseICalDates(strings.NewReader(ics))
+	if err != nil {
+		t.Fatalf("parseICalDates() error = %v", err)
+	}
+
+	expected := []string{"2024-12-25", "2024-12-26", "2025-01-01", "2025-07-04"}
+	if strings.Join(days, ",") != strings.Join(expected, ",") {
+		t.Errorf("parseICalDates() = %v, want %v", days, expected)
+	}
+}
+
+func TestResolveCalendars(t *testing.T) {
+	conf := &Config{
+		Calendars: []Calendar{
+			{
+				Name:  "holidays",
+				Dates: []string{"2024-12-25"},
+			},
+		},
+		Rules: []Rule{
+			{
+				Name:          "standup",
+				SkipCalendars: []string{"holidays"},
+			},
+			{
+				Name: "other",
+			},
+		},
+	}
+
+	err := conf.ResolveCalendars()
+	if err != nil {
+		t.Fatalf("ResolveCalendars() error = %v", err)
+	}
+
+	christmas := time.Date(2024, 12, 25, 9, 0, 0, 0, time.UTC)
+	if !conf.Rules[0].IsBlackout(christmas) {
+		t.Error("Expected standup to be blacked out on Dec 25")
+	}
+	if conf.Rules[0].IsBlackout(christmas.AddDate(0, 0, 1)) {
+		t.Error("Expected standup not to be blacked out on Dec 26")
+	}
+	if conf.Rules[1].IsBlackout(christmas) {
+		t.Error("Expected rule without skip_calendars not to be blacked out")
+	}
+
+	conf.Rules[1].SkipCalendars = []string{"missing"}
+	if err := conf.ResolveCalendars(); err == nil {
+		t.Error("Expected error for unknown calendar")
+	}
+}
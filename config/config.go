@@ -1,183 +1,372 @@
 package config
 
 import (
-	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"log/slog"
 	"os"
+	"sort"
+	"strings"
 	"time"
-
-	"github.com/BurntSushi/toml"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 type Config struct {
-	Rules        []Rule        `toml:"rule"`
-	Destinations []Destination `toml:"destination"`
-	Timezone     string        `toml:"timezone"`
+	Rules        []Rule        `toml:"rule,omitempty" json:"rule,omitempty" yaml:"rule,omitempty"`
+	Destinations []Destination `toml:"destination,omitempty" json:"destination,omitempty" yaml:"destination,omitempty"`
+	Timezone     string        `toml:"timezone,omitempty" json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	Calendars    []Calendar    `toml:"calendar,omitempty" json:"calendar,omitempty" yaml:"calendar,omitempty"`
+
+	// OrphanGracePeriod is how long state for a rule that is no longer in
+	// the config is kept before it is removed. Defaults to 7 days.
+	OrphanGracePeriod Duration `toml:"orphan_grace_period,omitempty" json:"orphan_grace_period,omitzero" yaml:"orphan_grace_period,omitempty"`
+	// OrphanAction is "prune" or "archive". Pruned state is deleted,
+	// archived state is moved aside and restored if the rule comes back.
+	// Defaults to "prune".
+	OrphanAction string `toml:"orphan_action,omitempty" json:"orphan_action,omitempty" yaml:"orphan_action,omitempty"`
+
+	// Links adds signed acknowledge and snooze links to notifications.
+	Links *Links `toml:"links,omitempty" json:"links,omitempty" yaml:"links,omitempty"`
+
+	// ErrorDestinations are notified about operational problems, such as
+	// a newly uploaded config that fails to load.
+	ErrorDestinations []string `toml:"error_destinations,omitempty" json:"error_destinations,omitempty" yaml:"error_destinations,omitempty"`
+
+	// Include lists more config files to load and merge into this one.
+	// Entries are relative to the including file and may be files, glob
+	// patterns, or directories (or S3 prefixes ending in "/"), whose .toml,
+	// .yaml, .yml and .json files are all loaded.
+	Include []string `toml:"include,omitempty" json:"include,omitempty" yaml:"include,omitempty"`
+
+	files   []string
+	secrets []string
+	// locs are where the top level settings were set, by key.
+	locs map[string]location
 }
 
-type Rule struct {
-	Name         string   `toml:"name"`
-	Cron         string   `toml:"cron"`
-	Destinations []string `toml:"destinations"`
-	Subject      string   `toml:"subject"`
-	Body         string   `toml:"body"`
+// Files returns the names of the files the config was loaded from.
+func (c *Config) Files() []string {
+	return c.files
 }
 
-type Destination struct {
-	ID string `toml:"id"`
-	// Type is a string of "sns" "slack_webhook" "ses"
-	Type string `toml:"type"`
+// Secrets returns the values references in the config resolved to. They
+// should be kept out of logs.
+func (c *Config) Secrets() []string {
+	return c.secrets
+}
 
-	// SNSARN is for type "sns"
-	SNSARN string `toml:"sns_arn"`
+// Links configures the one-click links embedded in notifications.
+type Links struct {
+	// BaseURL is where the link endpoint is served, e.g. the Lambda
+	// function URL or an API Gateway route in front of it.
+	BaseURL string `toml:"base_url,omitempty" json:"base_url,omitempty" yaml:"base_url,omitempty"`
+	// SigningKey is the HMAC key links are signed with. Changing it
+	// invalidates all outstanding links.
+	SigningKey string `toml:"signing_key,omitempty" json:"signing_key,omitempty" yaml:"signing_key,omitempty"`
+	// Expiry is how long a link stays valid after it is sent. Defaults to
+	// 7 days.
+	Expiry Duration `toml:"expiry,omitempty" json:"expiry,omitzero" yaml:"expiry,omitempty"`
+}
 
-	// WebhookURL is for type "slack_webhook"
-	WebhookURL string `toml:"webhook_url"`
+const (
+	DefaultLinkExpiry = 7 * 24 * time.Hour
 
-	// ToEmails is for type "ses"
-	ToEmails []string `toml:"to_emails"`
-	// FromEmail is for type "ses"
-	FromEmail string `toml:"from_email"`
+	minSigningKeyLen = 16
+)
+
+// LinkExpiry returns the configured link expiry with the default applied.
+func (l *Links) LinkExpiry() time.Duration {
+	if l.Expiry.Duration == 0 {
+		return DefaultLinkExpiry
+	}
+	return l.Expiry.Duration
 }
 
-func LoadConfig(ctx context.Context, s3Client *s3.Client, lgr *slog.Logger, configPath string) (*Config, error) {
-	var conf Config
-	if configPath != "" {
+const (
+	OrphanActionPrune   = "prune"
+	OrphanActionArchive = "archive"
 
-		f, err := os.Open(configPath)
-		if err != nil {
-			return nil, fmt.Errorf("open config file err %w", err)
-		}
-		defer f.Close()
-		_, err = toml.NewDecoder(f).Decode(&conf)
-		if err != nil {
-			return nil, fmt.Errorf("decode config: %w", err)
-		}
-	} else {
-		bucketName := os.Getenv("S3_CONFIG_BUCKET")
-		if bucketName == "" {
-			return nil, fmt.Errorf("S3_CONFIG_BUCKET environment variable not set")
-		}
+	DefaultOrphanGracePeriod = 7 * 24 * time.Hour
+)
 
-		confPath := os.Getenv("S3_CONFIG_PATH")
-		if confPath == "" {
-			return nil, fmt.Errorf("S3_CONFIG_PATH environment variable not set")
-		}
+// OrphanPolicy returns the configured orphan action and grace period with
+// defaults applied.
+func (c *Config) OrphanPolicy() (action string, grace time.Duration) {
+	action = c.OrphanAction
+	if action == "" {
+		action = OrphanActionPrune
+	}
+	grace = c.OrphanGracePeriod.Duration
+	if grace == 0 {
+		grace = DefaultOrphanGracePeriod
+	}
+	return action, grace
+}
 
-		confResp, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
-			Bucket: &bucketName,
-			Key:    &confPath,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("get config from s3: %w", err)
-		}
-		defer confResp.Body.Close()
+type Rule struct {
+	// ID optionally identifies the rule's state independently of its name,
+	// so a rule can be renamed without losing its history.
+	ID           string   `toml:"id,omitempty" json:"id,omitempty" yaml:"id,omitempty"`
+	Name         string   `toml:"name,omitempty" json:"name,omitempty" yaml:"name,omitempty"`
+	Cron         string   `toml:"cron,omitempty" json:"cron,omitempty" yaml:"cron,omitempty"`
+	Destinations []string `toml:"destinations,omitempty" json:"destinations,omitempty" yaml:"destinations,omitempty"`
+	Subject      string   `toml:"subject,omitempty" json:"subject,omitempty" yaml:"subject,omitempty"`
+	Body         string   `toml:"body,omitempty" json:"body,omitempty" yaml:"body,omitempty"`
+
+	// RRule is an RFC 5545 recurrence rule used instead of Cron. It must
+	// start with a DTSTART line, for example:
+	//
+	//	DTSTART;TZID=America/New_York:20261006T090000
+	//	RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=TU
+	RRule string `toml:"rrule,omitempty" json:"rrule,omitempty" yaml:"rrule,omitempty"`
+
+	// Every runs the rule at a fixed interval counted from Anchor instead of
+	// on a Cron or RRule schedule, e.g. every = "10d" with
+	// anchor = 2026-10-01T09:00:00Z. Occurrences always land on the anchor's
+	// grid, so a late run doesn't shift later ones.
+	Every  Duration  `toml:"every,omitempty" json:"every,omitzero" yaml:"every,omitempty"`
+	Anchor time.Time `toml:"anchor,omitempty" json:"anchor,omitzero" yaml:"anchor,omitempty"`
+
+	// Enabled defaults to true. A disabled rule keeps its state but is never
+	// due.
+	Enabled *bool `toml:"enabled,omitempty" json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// StartDate and EndDate optionally bound the dates the rule is active.
+	// They are "2006-01-02" dates in the config timezone; EndDate is
+	// inclusive.
+	StartDate string `toml:"start_date,omitempty" json:"start_date,omitempty" yaml:"start_date,omitempty"`
+	EndDate   string `toml:"end_date,omitempty" json:"end_date,omitempty" yaml:"end_date,omitempty"`
+
+	// MaxOccurrences stops the rule after it has fired this many times.
+	// Zero means no limit.
+	MaxOccurrences int `toml:"max_occurrences,omitzero" json:"max_occurrences,omitempty" yaml:"max_occurrences,omitempty"`
+
+	// SkipCalendars names calendars whose dates the rule should not fire on.
+	SkipCalendars []string `toml:"skip_calendars,omitempty" json:"skip_calendars,omitempty" yaml:"skip_calendars,omitempty"`
+	// CalendarPolicy is what happens to an occurrence that falls on a skip
+	// calendar date: "skip" (the default), "next_business_day" or
+	// "previous_business_day".
+	CalendarPolicy string `toml:"calendar_policy,omitempty" json:"calendar_policy,omitempty" yaml:"calendar_policy,omitempty"`
 
-		_, err = toml.NewDecoder(confResp.Body).Decode(&conf)
-		if err != nil {
-			return nil, fmt.Errorf("decode config: %w", err)
+	// Jitter delays each occurrence by a random amount up to this duration,
+	// or up to the time until the following occurrence if that is shorter.
+	// The delay is derived from the rule and occurrence, so it is stable
+	// across retries.
+	Jitter Duration `toml:"jitter,omitempty" json:"jitter,omitzero" yaml:"jitter,omitempty"`
+
+	// RepeatEvery re-sends an occurrence at this interval until it is
+	// acknowledged, at most RepeatMax times.
+	RepeatEvery Duration `toml:"repeat_every,omitempty" json:"repeat_every,omitzero" yaml:"repeat_every,omitempty"`
+	RepeatMax   int      `toml:"repeat_max,omitzero" json:"repeat_max,omitempty" yaml:"repeat_max,omitempty"`
+	// EscalateDestinations are added to repeats once EscalateAfter repeats
+	// have gone unacknowledged. EscalateAfter is required with them.
+	EscalateAfter        int      `toml:"escalate_after,omitzero" json:"escalate_after,omitempty" yaml:"escalate_after,omitempty"`
+	EscalateDestinations []string `toml:"escalate_destinations,omitempty" json:"escalate_destinations,omitempty" yaml:"escalate_destinations,omitempty"`
+
+	calendars []*Calendar
+	loc       location
+}
+
+// IsBlackout reports whether t's date is in one of the rule's skip
+// calendars.
+func (r *Rule) IsBlackout(t time.Time) bool {
+	for _, cal := range r.calendars {
+		if cal.Contains(t) {
+			return true
 		}
 	}
+	return false
+}
 
-	err := validateConfig(&conf)
-	if err != nil {
-		return nil, fmt.Errorf("validate config: %w", err)
-	}
+const dateFormat = "2006-01-02"
 
-	return &conf, nil
+func (r *Rule) IsEnabled() bool {
+	return r.Enabled == nil || *r.Enabled
 }
 
-func validateConfig(conf *Config) error {
-	if len(conf.Rules) == 0 {
-		return fmt.Errorf("at least one rule must be defined")
+// ActiveAt reports whether the rule is enabled and t falls within its
+// start and end dates. Dates are interpreted in t's location.
+func (r *Rule) ActiveAt(t time.Time) bool {
+	if !r.IsEnabled() {
+		return false
 	}
 
-	if len(conf.Destinations) == 0 {
-		return fmt.Errorf("at least one destination must be defined")
+	if r.StartDate != "" {
+		start, err := time.ParseInLocation(dateFormat, r.StartDate, t.Location())
+		if err == nil && t.Before(start) {
+			return false
+		}
 	}
 
-	destMap := make(map[string]bool)
-	for _, dest := range conf.Destinations {
-		if dest.ID == "" {
-			return fmt.Errorf("destination id cannot be empty")
+	if r.EndDate != "" {
+		end, err := time.ParseInLocation(dateFormat, r.EndDate, t.Location())
+		if err == nil && !t.Before(end.AddDate(0, 0, 1)) {
+			return false
 		}
-		if destMap[dest.ID] {
-			return fmt.Errorf("duplicate destination id: %s", dest.ID)
-		}
-		destMap[dest.ID] = true
+	}
 
-		err := validateDestination(&dest)
-		if err != nil {
-			return fmt.Errorf("destination %s: %w", dest.ID, err)
-		}
+	return true
+}
+
+// ScheduleExpr returns the rule's schedule: its cron expression, its RRULE,
+// or "@every <duration> from <anchor>" for fixed interval rules. The
+// scheduler stores it in state to detect schedule changes.
+func (r *Rule) ScheduleExpr() string {
+	if r.RRule != "" {
+		return strings.TrimSpace(r.RRule)
 	}
+	if r.Every.Duration > 0 {
+		return fmt.Sprintf("@every %s from %s", r.Every.Duration, r.Anchor.Format(time.RFC3339))
+	}
+	return r.Cron
+}
 
-	for _, rule := range conf.Rules {
-		err := validateRule(&rule, destMap)
-		if err != nil {
-			return fmt.Errorf("rule %s: %w", rule.Name, err)
+// ScheduleFingerprint identifies everything that decides when the rule
+// fires: its schedule, its jitter, its calendar policy and the dates of its
+// skip calendars, including dates read from calendar files. The scheduler
+// stores it in state and recomputes the next run time when it changes.
+func (r *Rule) ScheduleFingerprint() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\n", r.ScheduleExpr(), r.Jitter.Duration, r.CalendarPolicy, strings.Join(r.SkipCalendars, ","))
+	for _, cal := range r.calendars {
+		days := make([]string, 0, len(cal.days))
+		for day := range cal.days {
+			days = append(days, day)
 		}
+		sort.Strings(days)
+		fmt.Fprintf(h, "%s\x00%s\n", cal.Name, strings.Join(days, ","))
 	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
 
-	if conf.Timezone != "" {
-		_, err := time.LoadLocation(conf.Timezone)
-		if err != nil {
-			return fmt.Errorf("invalid timezone: %w", err)
+// FindRule returns the rule with the given name or id.
+func (c *Config) FindRule(nameOrID string) (Rule, bool) {
+	for _, rule := range c.Rules {
+		if rule.Name == nameOrID || (rule.ID != "" && rule.ID == nameOrID) {
+			return rule, true
 		}
 	}
+	return Rule{}, false
+}
 
-	return nil
+// StateKey returns the key the rule's state is stored under.
+func (r *Rule) StateKey() string {
+	if r.ID != "" {
+		return r.ID
+	}
+	return r.Name
 }
 
-func validateDestination(dest *Destination) error {
-	switch dest.Type {
-	case "sns":
-		if dest.SNSARN == "" {
-			return fmt.Errorf("sns_arn is required for sns destination")
-		}
-	case "slack_webhook":
-		if dest.WebhookURL == "" {
-			return fmt.Errorf("webhook_url is required for slack_webhook destination")
-		}
-	case "ses":
-		if dest.FromEmail == "" {
-			return fmt.Errorf("from_email is required for ses destination")
-		}
-		if len(dest.ToEmails) == 0 {
-			return fmt.Errorf("to_emails is required for ses destination")
+type Destination struct {
+	ID string `toml:"id,omitempty" json:"id,omitempty" yaml:"id,omitempty"`
+	// Type is a string of "sns" "slack_webhook" "ses"
+	Type string `toml:"type,omitempty" json:"type,omitempty" yaml:"type,omitempty"`
+
+	// SNSARN is for type "sns"
+	SNSARN string `toml:"sns_arn,omitempty" json:"sns_arn,omitempty" yaml:"sns_arn,omitempty"`
+
+	// WebhookURL is for type "slack_webhook"
+	WebhookURL string `toml:"webhook_url,omitempty" json:"webhook_url,omitempty" yaml:"webhook_url,omitempty"`
+
+	// ToEmails is for type "ses"
+	ToEmails []string `toml:"to_emails,omitempty" json:"to_emails,omitempty" yaml:"to_emails,omitempty"`
+	// FromEmail is for type "ses"
+	FromEmail string `toml:"from_email,omitempty" json:"from_email,omitempty" yaml:"from_email,omitempty"`
+
+	// QuietHours optionally holds back notifications to this destination
+	// during a daily window.
+	QuietHours *QuietHours `toml:"quiet_hours,omitempty" json:"quiet_hours,omitempty" yaml:"quiet_hours,omitempty"`
+
+	loc location
+}
+
+type QuietHours struct {
+	// Start and End are "15:04" times. A window whose end is before its
+	// start ends on the following day.
+	Start string `toml:"start,omitempty" json:"start,omitempty" yaml:"start,omitempty"`
+	End   string `toml:"end,omitempty" json:"end,omitempty" yaml:"end,omitempty"`
+	// Timezone defaults to the config timezone.
+	Timezone string `toml:"timezone,omitempty" json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	// Days limits the window to the days it starts on, e.g. ["sat", "sun"].
+	// Empty means every day.
+	Days []string `toml:"days,omitempty" json:"days,omitempty" yaml:"days,omitempty"`
+	// Action is "defer" (the default) to send held back notifications when
+	// the window ends, or "drop" to discard them.
+	Action string `toml:"action,omitempty" json:"action,omitempty" yaml:"action,omitempty"`
+}
+
+const (
+	QuietHoursDefer = "defer"
+	QuietHoursDrop  = "drop"
+
+	clockFormat = "15:04"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Location returns the quiet hours timezone, or def if none is set.
+func (q *QuietHours) Location(def *time.Location) *time.Location {
+	if q.Timezone != "" {
+		if loc, err := time.LoadLocation(q.Timezone); err == nil {
+			return loc
 		}
-	case "log":
-		return nil
-	default:
-		return fmt.Errorf("unsupported destination type: %s", dest.Type)
 	}
-	return nil
+	return def
 }
 
-func validateRule(rule *Rule, destMap map[string]bool) error {
-	if rule.Name == "" {
-		return fmt.Errorf("rule name cannot be empty")
+// Window returns the quiet window that starts on day's date, in day's
+// location. ok is false if the window doesn't apply on that day.
+func (q *QuietHours) Window(day time.Time) (start, end time.Time, ok bool) {
+	loc := day.Location()
+
+	if len(q.Days) > 0 {
+		var match bool
+		for _, d := range q.Days {
+			if weekdays[strings.ToLower(d)] == day.Weekday() {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return time.Time{}, time.Time{}, false
+		}
 	}
-	if rule.Cron == "" {
-		return fmt.Errorf("cron expression cannot be empty")
+
+	startClock, err := time.Parse(clockFormat, q.Start)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
 	}
-	if len(rule.Destinations) == 0 {
-		return fmt.Errorf("at least one destination must be specified")
+	endClock, err := time.Parse(clockFormat, q.End)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
 	}
-	if rule.Subject == "" {
-		return fmt.Errorf("subject cannot be empty")
+
+	y, m, d := day.Date()
+	start = time.Date(y, m, d, startClock.Hour(), startClock.Minute(), 0, 0, loc)
+	end = time.Date(y, m, d, endClock.Hour(), endClock.Minute(), 0, 0, loc)
+	if !end.After(start) {
+		end = time.Date(y, m, d+1, endClock.Hour(), endClock.Minute(), 0, 0, loc)
 	}
-	if rule.Body == "" {
-		return fmt.Errorf("body cannot be empty")
+
+	return start, end, true
+}
+
+func s3ConfigLocation() (bucket, key string, err error) {
+	bucket = os.Getenv("S3_CONFIG_BUCKET")
+	if bucket == "" {
+		return "", "", fmt.Errorf("S3_CONFIG_BUCKET environment variable not set")
 	}
 
-	for _, destID := range rule.Destinations {
-		if !destMap[destID] {
-			return fmt.Errorf("destination %s not found", destID)
-		}
+	key = os.Getenv("S3_CONFIG_PATH")
+	if key == "" {
+		return "", "", fmt.Errorf("S3_CONFIG_PATH environment variable not set")
 	}
 
-	return nil
+	return bucket, key, nil
 }
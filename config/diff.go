@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Diff summarizes the differences between two configs. Rules are matched by
+// state key, so a renamed rule with an id shows as changed rather than
+// removed and added.
+type Diff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+	// Settings is set if anything other than the rules changed, such as
+	// destinations, calendars or the timezone.
+	Settings bool
+}
+
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 && !d.Settings
+}
+
+func (d Diff) String() string {
+	var parts []string
+	if len(d.Added) > 0 {
+		parts = append(parts, fmt.Sprintf("added rules %s", strings.Join(d.Added, ", ")))
+	}
+	if len(d.Removed) > 0 {
+		parts = append(parts, fmt.Sprintf("removed rules %s", strings.Join(d.Removed, ", ")))
+	}
+	if len(d.Changed) > 0 {
+		parts = append(parts, fmt.Sprintf("changed rules %s", strings.Join(d.Changed, ", ")))
+	}
+	if d.Settings {
+		parts = append(parts, "changed settings")
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// DiffConfigs compares old and new, listing rules by state key in config
+// order.
+func DiffConfigs(old, new *Config) Diff {
+	var d Diff
+
+	oldContent, newContent := contentOf(old), contentOf(new)
+	old, new = &oldContent, &newContent
+
+	oldRules := make(map[string]Rule)
+	for _, rule := range old.Rules {
+		oldRules[rule.StateKey()] = rule
+	}
+	newKeys := make(map[string]bool)
+
+	for _, rule := range new.Rules {
+		key := rule.StateKey()
+		newKeys[key] = true
+
+		oldRule, exists := oldRules[key]
+		if !exists {
+			d.Added = append(d.Added, key)
+		} else if !reflect.DeepEqual(oldRule, rule) {
+			d.Changed = append(d.Changed, key)
+		}
+	}
+
+	for _, rule := range old.Rules {
+		if !newKeys[rule.StateKey()] {
+			d.Removed = append(d.Removed, rule.StateKey())
+		}
+	}
+
+	oldSettings, newSettings := *old, *new
+	oldSettings.Rules, newSettings.Rules = nil, nil
+	d.Settings = !reflect.DeepEqual(oldSettings, newSettings)
+
+	return d
+}
+
+// contentOf returns a copy of c without where it was loaded from, so
+// moving a rule to another file or line isn't a change.
+func contentOf(c *Config) Config {
+	out := *c
+	out.files, out.locs = nil, nil
+
+	out.Rules = make([]Rule, len(c.Rules))
+	for i, rule := range c.Rules {
+		// Calendars are compared as settings
+		rule.loc, rule.calendars = location{}, nil
+		out.Rules[i] = rule
+	}
+	out.Destinations = make([]Destination, len(c.Destinations))
+	for i, dest := range c.Destinations {
+		dest.loc = location{}
+		out.Destinations[i] = dest
+	}
+	out.Calendars = make([]Calendar, len(c.Calendars))
+	for i, cal := range c.Calendars {
+		cal.loc = location{}
+		out.Calendars[i] = cal
+	}
+	return out
+}
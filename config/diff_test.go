@@ -0,0 +1,48 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	old := &Config{
+		Timezone: "America/New_York",
+		Rules: []Rule{
+			{Name: "standup", Cron: "0 9 * * 1-5"},
+			{ID: "cert", Name: "renew_cert", Cron: "0 9 1 * *"},
+			{Name: "retired", Cron: "0 9 * * *"},
+		},
+	}
+	new := &Config{
+		Timezone: "America/New_York",
+		Rules: []Rule{
+			{Name: "standup", Cron: "0 9 * * 1-5"},
+			{ID: "cert", Name: "renew_certificate", Cron: "0 9 1 * *"},
+			{Name: "water_plants", Cron: "0 8 * * 6"},
+		},
+	}
+
+	d := DiffConfigs(old, new)
+	want := Diff{
+		Added:   []string{"water_plants"},
+		Removed: []string{"retired"},
+		Changed: []string{"cert"},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("DiffConfigs() = %+v, want %+v", d, want)
+	}
+	if got := d.String(); got != "added rules water_plants; removed rules retired; changed rules cert" {
+		t.Errorf("String() = %q", got)
+	}
+
+	if d := DiffConfigs(old, old); !d.Empty() {
+		t.Errorf("Expected no changes comparing a config to itself, got %s", d)
+	}
+
+	tz := *old
+	tz.Timezone = "UTC"
+	if d := DiffConfigs(old, &tz); !d.Settings || len(d.Changed) != 0 {
+		t.Errorf("Expected only a settings change, got %+v", d)
+	}
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that decodes from strings such as "90m",
+// "36h" or "10d". The "d" suffix is accepted as a whole number of 24 hour
+// days since time.ParseDuration has no unit larger than hours.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	s := string(text)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		d.Duration = time.Duration(n) * 24 * time.Hour
+		return nil
+	}
+
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	d.Duration = dur
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	if d.Duration != 0 && d.Duration%(24*time.Hour) == 0 {
+		return []byte(fmt.Sprintf("%dd", d.Duration/(24*time.Hour))), nil
+	}
+	return []byte(d.Duration.String()), nil
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is a config file format. All formats map onto the same structs
+// with the same keys and are validated the same way.
+type Format string
+
+const (
+	FormatTOML Format = "toml"
+	FormatYAML Format = "yaml"
+	FormatJSON Format = "json"
+)
+
+// ParseFormat parses a format name. Empty means no format.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case "", FormatTOML, FormatYAML, FormatJSON:
+		return f, nil
+	case "yml":
+		return FormatYAML, nil
+	default:
+		return "", fmt.Errorf("unknown config format %q, expected toml, yaml or json", s)
+	}
+}
+
+// FormatFromPath returns the format of a file or S3 key from its
+// extension. ok is false if the extension isn't a config format.
+func FormatFromPath(name string) (f Format, ok bool) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".toml":
+		return FormatTOML, true
+	case ".yaml", ".yml":
+		return FormatYAML, true
+	case ".json":
+		return FormatJSON, true
+	}
+	return "", false
+}
+
+// formatFor returns the format to decode name with: force if set, else the
+// format from its extension, else TOML.
+func formatFor(name string, force Format) Format {
+	if force != "" {
+		return force
+	}
+	if f, ok := FormatFromPath(name); ok {
+		return f
+	}
+	return FormatTOML
+}
+
+// decodeConfig decodes a config file. Keys that don't map onto the config
+// are rejected in every format, so typos don't go unnoticed.
+func decodeConfig(r io.Reader, format Format, conf *Config) error {
+	switch format {
+	case FormatYAML:
+		dec := yaml.NewDecoder(r)
+		dec.KnownFields(true)
+		err := dec.Decode(conf)
+		if err == io.EOF {
+			// An empty document
+			return nil
+		}
+		return err
+	case FormatJSON:
+		dec := json.NewDecoder(r)
+		dec.DisallowUnknownFields()
+		return dec.Decode(conf)
+	default:
+		md, err := toml.NewDecoder(r).Decode(conf)
+		if err != nil {
+			return err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, k := range undecoded {
+				keys[i] = k.String()
+			}
+			return fmt.Errorf("unknown keys: %s", strings.Join(keys, ", "))
+		}
+		return nil
+	}
+}
+
+// decodeErrorLine returns the line of data a decode error is on, or zero if
+// the decoder didn't say.
+func decodeErrorLine(err error, data []byte) int {
+	var tomlErr toml.ParseError
+	if errors.As(err, &tomlErr) {
+		return tomlErr.Position.Line
+	}
+
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return 0
+	}
+	offset = min(offset, int64(len(data)))
+	return 1 + bytes.Count(data[:offset], []byte("\n"))
+}
+
+// Encode writes conf in the given format. Unset fields are left out.
+func Encode(w io.Writer, conf *Config, format Format) error {
+	switch format {
+	case FormatYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		err := enc.Encode(conf)
+		if err != nil {
+			return err
+		}
+		return enc.Close()
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(conf)
+	case FormatTOML, "":
+		enc := toml.NewEncoder(w)
+		enc.Indent = ""
+		return enc.Encode(conf)
+	default:
+		return fmt.Errorf("unknown config format %q", format)
+	}
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const formatTestTOML = `
+timezone = "America/New_York"
+orphan_grace_period = "3d"
+
+[[destination]]
+id = "pager"
+type = "log"
+
+[destination.quiet_hours]
+start = "22:00"
+end = "07:00"
+days = ["sat", "sun"]
+
+[[calendar]]
+name = "holidays"
+dates = ["2026-12-25"]
+
+[[rule]]
+name = "standup"
+cron = "0 9 * * *"
+destinations = ["pager"]
+subject = "Standup"
+body = "Time for standup"
+skip_calendars = ["holidays"]
+repeat_every = "30m"
+repeat_max = 3
+
+[[rule]]
+id = "plants"
+name = "water plants"
+every = "10d"
+anchor = 2026-10-01T09:00:00Z
+enabled = false
+destinations = ["pager"]
+subject = "Water the plants"
+body = "And the herbs"
+`
+
+const formatTestYAML = `
+timezone: America/New_York
+orphan_grace_period: 3d
+destination:
+  - id: pager
+    type: log
+    quiet_hours:
+      start: "22:00"
+      end: "07:00"
+      days: [sat, sun]
+calendar:
+  - name: holidays
+    dates: ["2026-12-25"]
+rule:
+  - name: standup
+    cron: "0 9 * * *"
+    destinations: [pager]
+    subject: Standup
+    body: Time for standup
+    skip_calendars: [holidays]
+    repeat_every: 30m
+    repeat_max: 3
+  - id: plants
+    name: water plants
+    every: 10d
+    anchor: 2026-10-01T09:00:00Z
+    enabled: false
+    destinations: [pager]
+    subject: Water the plants
+    body: And the herbs
+`
+
+const formatTestJSON = `{
+  "timezone": "America/New_York",
+  "orphan_grace_period": "3d",
+  "destination": [
+    {"id": "pager", "type": "log", "quiet_hours": {"start": "22:00", "end": "07:00", "days": ["sat", "sun"]}}
+  ],
+  "calendar": [{"name": "holidays", "dates": ["2026-12-25"]}],
+  "rule": [
+    {
+      "name": "standup",
+      "cron": "0 9 * * *",
+      "destinations": ["pager"],
+      "subject": "Standup",
+      "body": "Time for standup",
+      "skip_calendars": ["holidays"],
+      "repeat_every": "30m",
+      "repeat_max": 3
+    },
+    {
+      "id": "plants",
+      "name": "water plants",
+      "every": "10d",
+      "anchor": "2026-10-01T09:00:00Z",
+      "enabled": false,
+      "destinations": ["pager"],
+      "subject": "Water the plants",
+      "body": "And the herbs"
+    }
+  ]
+}`
+
+func loadFormatTestConfig(t *testing.T, name, body string) *Config {
+	t.Helper()
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(body), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := LoadConfig(context.Background(), nil, lgr, path)
+	if err != nil {
+		t.Fatalf("LoadConfig(%s) error = %v", name, err)
+	}
+	content := contentOf(conf)
+	return &content
+}
+
+func TestFormats(t *testing.T) {
+	want := loadFormatTestConfig(t, "config.toml", formatTestTOML)
+
+	for name, body := range map[string]string{
+		"config.yaml": formatTestYAML,
+		"config.yml":  formatTestYAML,
+		"config.json": formatTestJSON,
+	} {
+		got := loadFormatTestConfig(t, name, body)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %s to match the TOML config\ngot:  %+v\nwant: %+v", name, got, want)
+		}
+	}
+
+	for _, format := range []Format{FormatTOML, FormatYAML, FormatJSON} {
+		var buf bytes.Buffer
+		err := Encode(&buf, want, format)
+		if err != nil {
+			t.Fatalf("Encode(%s) error = %v", format, err)
+		}
+
+		got := loadFormatTestConfig(t, "converted."+string(format), buf.String())
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %s round trip to match\n%s", format, buf.String())
+		}
+	}
+}
+
+func TestForceFormat(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	path := filepath.Join(t.TempDir(), "config")
+	err := os.WriteFile(path, []byte(formatTestYAML), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loader := NewLoader()
+	loader.Format = FormatYAML
+	conf, err := loader.LoadConfig(context.Background(), nil, lgr, path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if len(conf.Rules) != 2 {
+		t.Errorf("Expected 2 rules from a forced YAML config, got %d", len(conf.Rules))
+	}
+
+	// The package level loader is unaffected
+	_, err = LoadConfig(context.Background(), nil, lgr, path)
+	if err == nil {
+		t.Errorf("Expected the YAML config to fail to load as TOML")
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "config.toml",
+			body: strings.Replace(formatTestTOML, "destinations = [\"pager\"]\nsubject = \"Standup\"", "destination = [\"pager\"]\nsubject = \"Standup\"", 1),
+			want: "unknown keys: rule.destination",
+		},
+		{
+			name: "config.yaml",
+			body: strings.Replace(formatTestYAML, "    destinations: [pager]\n    subject: Standup", "    destination: [pager]\n    subject: Standup", 1),
+			want: "field destination not found",
+		},
+		{
+			name: "config.json",
+			body: strings.Replace(formatTestJSON, `"destinations": ["pager"],
+      "subject": "Standup"`, `"destination": ["pager"],
+      "subject": "Standup"`, 1),
+			want: `unknown field "destination"`,
+		},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), tt.name)
+		err := os.WriteFile(path, []byte(tt.body), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = LoadConfig(context.Background(), nil, lgr, path)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Expected %s to fail with %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
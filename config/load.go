@@ -0,0 +1,596 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// A config is loaded from a single file, from every config file in a local
+// directory or under an S3 prefix (an S3_CONFIG_PATH ending in "/"), and
+// from the files named by each file's include list. Rules, destinations,
+// calendars and error destinations from all the files are merged; the
+// remaining settings may be set in at most one file.
+
+// ErrNotModified is returned by LoadConfigIfModified when the config still
+// matches the given tag.
+var ErrNotModified = errors.New("config not modified")
+
+// multiTagPrefix marks tags that cover more than one file. They can't be
+// checked with a single conditional read, so every file is re-read and the
+// combined tag compared instead.
+const multiTagPrefix = "multi:"
+
+// A Loader loads configs. Its settings apply only to its own loads, so
+// loaders with different settings can be used side by side.
+type Loader struct {
+	// Format, if set, is the format of every config file regardless of
+	// its extension. It's for configs stored without a meaningful
+	// extension.
+	Format Format
+	// Resolvers maps reference schemes to their resolvers. A nil map
+	// leaves references unresolved, for example to convert a config
+	// without writing out its secrets.
+	Resolvers map[string]Resolver
+}
+
+// NewLoader returns a Loader that detects formats from file extensions and
+// resolves the DefaultResolvers.
+func NewLoader() *Loader {
+	return &Loader{Resolvers: DefaultResolvers()}
+}
+
+// LoadConfig loads a config with NewLoader.
+func LoadConfig(ctx context.Context, s3Client *s3.Client, lgr *slog.Logger, configPath string) (*Config, error) {
+	return NewLoader().LoadConfig(ctx, s3Client, lgr, configPath)
+}
+
+// LoadConfigIfModified is Loader.LoadConfigIfModified with NewLoader.
+func LoadConfigIfModified(ctx context.Context, s3Client *s3.Client, lgr *slog.Logger, configPath, tag string) (*Config, string, error) {
+	return NewLoader().LoadConfigIfModified(ctx, s3Client, lgr, configPath, tag)
+}
+
+// Fingerprint is Loader.Fingerprint with NewLoader.
+func Fingerprint(ctx context.Context, s3Client *s3.Client, configPath string) (string, error) {
+	return NewLoader().Fingerprint(ctx, s3Client, configPath)
+}
+
+// LoadConfig loads and validates the config at configPath, or in S3 if
+// configPath is empty.
+func (l *Loader) LoadConfig(ctx context.Context, s3Client *s3.Client, lgr *slog.Logger, configPath string) (*Config, error) {
+	conf, _, err := l.LoadConfigIfModified(ctx, s3Client, lgr, configPath, "")
+	return conf, err
+}
+
+// LoadConfigIfModified is LoadConfig with a conditional read. If tag is
+// set and still matches the S3 object's ETag, or the local file's
+// fingerprint, it returns ErrNotModified without downloading or decoding
+// anything. Configs split across several files are re-read and decoded,
+// but not merged, resolved or validated, when nothing changed. The returned tag identifies
+// the version that was read. It is set even when that version fails to
+// decode or validate, so callers can tell a bad config they've already
+// seen from a new one.
+//
+// Values resolved from references are only looked up again when the
+// config is read again, so callers that cache the config must reload it
+// with an empty tag to pick up a rotated secret.
+func (l *Loader) LoadConfigIfModified(ctx context.Context, s3Client *s3.Client, lgr *slog.Logger, configPath, tag string) (*Config, string, error) {
+	r := newConfigReader(ctx, s3Client, l.Format)
+	err := r.readRoot(configPath, tag)
+	if errors.Is(err, ErrNotModified) {
+		return nil, tag, err
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	newTag := r.tag()
+	if tag != "" && tag == newTag {
+		return nil, tag, ErrNotModified
+	}
+
+	conf, err := r.merge()
+	if err != nil {
+		return nil, newTag, fmt.Errorf("read config: %w", err)
+	}
+
+	err = resolveReferences(ctx, conf, l.Resolvers)
+	if err != nil {
+		return nil, newTag, fmt.Errorf("resolve config: %w", err)
+	}
+
+	err = loadCalendarFiles(ctx, s3Client, conf)
+	if err != nil {
+		return nil, newTag, fmt.Errorf("load calendars: %w", err)
+	}
+
+	err = validateConfig(conf)
+	if err != nil {
+		return nil, newTag, fmt.Errorf("validate config: %w", err)
+	}
+
+	err = conf.ResolveCalendars()
+	if err != nil {
+		return nil, newTag, fmt.Errorf("resolve calendars: %w", err)
+	}
+
+	return conf, newTag, nil
+}
+
+// Fingerprint returns a value that changes when the config changes. Local
+// files are cheap to read, so every file, including included ones, is
+// checked by modification time and size. In S3 only the config object's
+// ETag, or the ETags listed under a config prefix, are checked, without
+// downloading anything.
+func (l *Loader) Fingerprint(ctx context.Context, s3Client *s3.Client, configPath string) (string, error) {
+	if configPath != "" {
+		r := newConfigReader(ctx, s3Client, l.Format)
+		err := r.readRoot(configPath, "")
+		if err != nil {
+			return "", err
+		}
+		return r.tag(), nil
+	}
+
+	bucketName, confPath, err := s3ConfigLocation()
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasSuffix(confPath, "/") {
+		objects, err := listS3Configs(ctx, s3Client, bucketName, confPath)
+		if err != nil {
+			return "", err
+		}
+		return combinedTag(objects), nil
+	}
+
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &bucketName,
+		Key:    &confPath,
+	})
+	if err != nil {
+		return "", fmt.Errorf("head config in s3: %w", err)
+	}
+	if head.ETag == nil {
+		return "", nil
+	}
+	return *head.ETag, nil
+}
+
+func fileFingerprint(fi os.FileInfo) string {
+	return fmt.Sprintf("%d-%d", fi.ModTime().UnixNano(), fi.Size())
+}
+
+// sourceFile is one file a config is assembled from.
+type sourceFile struct {
+	name string
+	tag  string
+	conf Config
+	// err is set if the file failed to decode, and errLine to the line
+	// of the problem if the decoder reported one.
+	err     error
+	errLine int
+	// pos has the lines of TOML files' tables and keys.
+	pos *tomlPositions
+}
+
+// configReader reads a config's files, following includes.
+type configReader struct {
+	ctx      context.Context
+	s3Client *s3.Client
+	format   Format
+
+	files []sourceFile
+	seen  map[string]bool
+	// multi is set once the config can be made up of more than one file,
+	// even if only one was found.
+	multi bool
+}
+
+func newConfigReader(ctx context.Context, s3Client *s3.Client, format Format) *configReader {
+	return &configReader{
+		ctx:      ctx,
+		s3Client: s3Client,
+		format:   format,
+		seen:     make(map[string]bool),
+	}
+}
+
+// readRoot reads the config at configPath, or in S3 if configPath is
+// empty. A single file is only read if it doesn't match tag.
+func (r *configReader) readRoot(configPath, tag string) error {
+	if strings.HasPrefix(tag, multiTagPrefix) {
+		tag = ""
+	}
+
+	if configPath != "" {
+		fi, err := os.Stat(configPath)
+		if err != nil {
+			return fmt.Errorf("open config file err %w", err)
+		}
+		if fi.IsDir() {
+			r.multi = true
+			return r.readLocalDir(configPath)
+		}
+		return r.readLocal(configPath, tag)
+	}
+
+	bucketName, confPath, err := s3ConfigLocation()
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(confPath, "/") {
+		r.multi = true
+		return r.readS3Prefix(bucketName, confPath)
+	}
+	return r.readS3(bucketName, confPath, tag)
+}
+
+func (r *configReader) readLocal(name, tag string) error {
+	name = filepath.Clean(name)
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	if r.seen[abs] {
+		return nil
+	}
+	r.seen[abs] = true
+
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("open config file err %w", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat config file: %w", err)
+	}
+	fp := fileFingerprint(fi)
+	if tag != "" && tag == fp {
+		return ErrNotModified
+	}
+
+	conf := r.add(name, fp, f)
+
+	for _, inc := range conf.Include {
+		if bucket, key, ok := parseS3URL(inc); ok {
+			err = r.includeS3(bucket, key)
+		} else {
+			if !filepath.IsAbs(inc) {
+				inc = filepath.Join(filepath.Dir(name), inc)
+			}
+			err = r.includeLocal(inc)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: include %s: %w", name, inc, err)
+		}
+	}
+	return nil
+}
+
+// includeLocal reads a local file, directory or glob pattern.
+func (r *configReader) includeLocal(pattern string) error {
+	if !strings.ContainsAny(pattern, "*?[") {
+		fi, err := os.Stat(pattern)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return r.readLocalDir(pattern)
+		}
+		return r.readLocal(pattern, "")
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			continue
+		}
+		err = r.readLocal(m, "")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLocalDir reads every config file directly in dir, in name order.
+func (r *configReader) readLocalDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("read config dir: %w", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() || !isConfigFile(e.Name()) {
+			continue
+		}
+		err = r.readLocal(filepath.Join(dir, e.Name()), "")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *configReader) readS3(bucket, key, tag string) error {
+	name := "s3://" + bucket + "/" + key
+	if r.seen[name] {
+		return nil
+	}
+	r.seen[name] = true
+
+	input := &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	}
+	if tag != "" {
+		input.IfNoneMatch = &tag
+	}
+
+	resp, err := r.s3Client.GetObject(r.ctx, input)
+	if err != nil {
+		var respErr *awshttp.ResponseError
+		if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotModified {
+			return ErrNotModified
+		}
+		return fmt.Errorf("get config from s3: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var etag string
+	if resp.ETag != nil {
+		etag = *resp.ETag
+	}
+
+	conf := r.add(name, etag, resp.Body)
+
+	for _, inc := range conf.Include {
+		incBucket, incKey, ok := parseS3URL(inc)
+		if !ok {
+			incBucket = bucket
+			incKey = path.Join(path.Dir(key), inc)
+			if strings.HasSuffix(inc, "/") {
+				incKey += "/"
+			}
+		}
+		err = r.includeS3(incBucket, incKey)
+		if err != nil {
+			return fmt.Errorf("%s: include %s: %w", name, inc, err)
+		}
+	}
+	return nil
+}
+
+// includeS3 reads an S3 object, or every config object under a prefix
+// ending in "/".
+func (r *configReader) includeS3(bucket, key string) error {
+	if strings.HasSuffix(key, "/") {
+		return r.readS3Prefix(bucket, key)
+	}
+	return r.readS3(bucket, key, "")
+}
+
+// readS3Prefix reads every config object directly under prefix, in key
+// order.
+func (r *configReader) readS3Prefix(bucket, prefix string) error {
+	objects, err := listS3Configs(r.ctx, r.s3Client, bucket, prefix)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		_, key, _ := parseS3URL(obj.name)
+		err = r.readS3(bucket, key, "")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listS3Configs lists the config objects directly under prefix with their
+// ETags.
+func listS3Configs(ctx context.Context, s3Client *s3.Client, bucket, prefix string) ([]sourceFile, error) {
+	delim := "/"
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket:    &bucket,
+		Prefix:    &prefix,
+		Delimiter: &delim,
+	})
+
+	var objects []sourceFile
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list configs in s3: %w", err)
+		}
+		for _, obj := range page.Contents {
+			if obj.Key == nil || !isConfigFile(*obj.Key) {
+				continue
+			}
+			var etag string
+			if obj.ETag != nil {
+				etag = *obj.ETag
+			}
+			objects = append(objects, sourceFile{
+				name: "s3://" + bucket + "/" + *obj.Key,
+				tag:  etag,
+			})
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].name < objects[j].name
+	})
+	return objects, nil
+}
+
+func isConfigFile(name string) bool {
+	_, ok := FormatFromPath(name)
+	return ok
+}
+
+// add decodes a file and records it. Decode errors are kept on the file
+// and reported by merge, so a broken file still counts towards the tag.
+func (r *configReader) add(name, tag string, body io.Reader) Config {
+	f := sourceFile{
+		name: name,
+		tag:  tag,
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		f.err = err
+	} else {
+		format := formatFor(name, r.format)
+		f.err = decodeConfig(bytes.NewReader(data), format, &f.conf)
+		f.errLine = decodeErrorLine(f.err, data)
+		if format == FormatTOML {
+			f.pos = scanTOML(name, data)
+		}
+	}
+	if len(f.conf.Include) > 0 {
+		r.multi = true
+	}
+	r.files = append(r.files, f)
+	return f.conf
+}
+
+// tag returns the tag of a single file config, or a combined tag for
+// configs made up of several files.
+func (r *configReader) tag() string {
+	if len(r.files) == 1 && !r.multi {
+		return r.files[0].tag
+	}
+	return combinedTag(r.files)
+}
+
+func combinedTag(files []sourceFile) string {
+	h := sha256.New()
+	for _, f := range files {
+		fmt.Fprintf(h, "%s\x00%s\n", f.name, f.tag)
+	}
+	return multiTagPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// merge combines the files into one config. Items and settings remember
+// where they were defined, for error messages. Files that fail to decode
+// and settings set in more than one file are all reported, as
+// ValidationErrors.
+func (r *configReader) merge() (*Config, error) {
+	var v validator
+	for _, f := range r.files {
+		if f.err != nil {
+			v.report(location{file: f.name, line: f.errLine}, "", ValidationError{}, f.err)
+		}
+	}
+
+	for i := range r.files {
+		r.files[i].locate()
+	}
+
+	if !r.multi && len(r.files) == 1 {
+		if len(v.errs) > 0 {
+			return nil, v.errs
+		}
+		conf := r.files[0].conf
+		conf.files = []string{r.files[0].name}
+		return &conf, nil
+	}
+
+	var conf Config
+	conf.locs = make(map[string]location)
+
+	for _, f := range r.files {
+		if f.err != nil {
+			continue
+		}
+		part := f.conf
+		conf.files = append(conf.files, f.name)
+		conf.Rules = append(conf.Rules, part.Rules...)
+		conf.Destinations = append(conf.Destinations, part.Destinations...)
+		conf.Calendars = append(conf.Calendars, part.Calendars...)
+		conf.ErrorDestinations = append(conf.ErrorDestinations, part.ErrorDestinations...)
+		if len(part.ErrorDestinations) > 0 {
+			conf.locs["error_destinations"] = part.locs["error_destinations"]
+		}
+
+		settings := []struct {
+			key   string
+			set   bool
+			apply func()
+		}{
+			{"timezone", part.Timezone != "", func() { conf.Timezone = part.Timezone }},
+			{"orphan_grace_period", part.OrphanGracePeriod.Duration != 0, func() { conf.OrphanGracePeriod = part.OrphanGracePeriod }},
+			{"orphan_action", part.OrphanAction != "", func() { conf.OrphanAction = part.OrphanAction }},
+			{"links", part.Links != nil, func() { conf.Links = part.Links }},
+		}
+		for _, s := range settings {
+			if !s.set {
+				continue
+			}
+			if prev, ok := conf.locs[s.key]; ok {
+				v.report(part.locs[s.key], s.key, ValidationError{}, fmt.Errorf("%s is already set at %s", s.key, prev))
+				continue
+			}
+			conf.locs[s.key] = part.locs[s.key]
+			s.apply()
+		}
+	}
+
+	if len(v.errs) > 0 {
+		return nil, v.errs
+	}
+	return &conf, nil
+}
+
+// locate records where the file's items and settings were defined.
+func (f *sourceFile) locate() {
+	item := func(table string, i int) location {
+		if loc, ok := f.pos.item(table, i); ok {
+			return loc
+		}
+		return location{file: f.name}
+	}
+	for i := range f.conf.Rules {
+		f.conf.Rules[i].loc = item("rule", i)
+	}
+	for i := range f.conf.Destinations {
+		f.conf.Destinations[i].loc = item("destination", i)
+	}
+	for i := range f.conf.Calendars {
+		f.conf.Calendars[i].loc = item("calendar", i)
+	}
+
+	f.conf.locs = make(map[string]location)
+	for _, key := range []string{"timezone", "orphan_grace_period", "orphan_action", "links", "error_destinations"} {
+		loc := location{file: f.name}
+		if f.pos != nil {
+			loc.line = f.pos.top[key]
+			loc.keys = f.pos.top
+		}
+		f.conf.locs[key] = loc
+	}
+}
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const loadTestConfig = `
+[[destination]]
+id = "pager"
+type = "log"
+
+[[rule]]
+name = "standup"
+cron = "0 9 * * *"
+destinations = ["pager"]
+subject = "Standup"
+body = "Time for standup"
+`
+
+func TestLoadConfigIfModified(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(path, []byte(loadTestConfig), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf, tag, err := LoadConfigIfModified(ctx, nil, lgr, path, "")
+	if err != nil {
+		t.Fatalf("LoadConfigIfModified() error = %v", err)
+	}
+	if len(conf.Rules) != 1 || tag == "" {
+		t.Fatalf("Expected 1 rule and a tag, got %d rules and tag %q", len(conf.Rules), tag)
+	}
+
+	_, sameTag, err := LoadConfigIfModified(ctx, nil, lgr, path, tag)
+	if !errors.Is(err, ErrNotModified) || sameTag != tag {
+		t.Fatalf("Expected ErrNotModified for an unchanged config, got %v", err)
+	}
+
+	err = os.WriteFile(path, []byte("error_destinations = [\"missing\"]\n"+loadTestConfig), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, badTag, err := LoadConfigIfModified(ctx, nil, lgr, path, tag)
+	if err == nil || errors.Is(err, ErrNotModified) {
+		t.Fatalf("Expected a validation error for an unknown error destination, got %v", err)
+	}
+	if badTag == "" || badTag == tag {
+		t.Errorf("Expected a new tag identifying the bad config, got %q", badTag)
+	}
+}
+
+func writeConfigFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, body := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(body), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+const (
+	destinationsFile = `
+timezone = "America/New_York"
+
+[[destination]]
+id = "pager"
+type = "log"
+`
+	standupFile = `
+[[rule]]
+name = "standup"
+cron = "0 9 * * *"
+destinations = ["pager"]
+subject = "Standup"
+body = "Time for standup"
+`
+	retroFile = `
+[[rule]]
+name = "retro"
+cron = "0 15 * * fri"
+destinations = ["pager"]
+subject = "Retro"
+body = "Time for retro"
+`
+)
+
+func TestLoadConfigDirectory(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"destinations.toml": destinationsFile,
+		"standup.toml":      standupFile,
+		"retro.toml":        retroFile,
+		"notes.txt":         "not a config file",
+	})
+
+	conf, tag, err := LoadConfigIfModified(ctx, nil, lgr, dir, "")
+	if err != nil {
+		t.Fatalf("LoadConfigIfModified() error = %v", err)
+	}
+	if len(conf.Rules) != 2 || len(conf.Destinations) != 1 {
+		t.Errorf("Expected 2 rules and 1 destination, got %d rules and %d destinations", len(conf.Rules), len(conf.Destinations))
+	}
+	if conf.Timezone != "America/New_York" {
+		t.Errorf("Expected timezone from destinations.toml, got %q", conf.Timezone)
+	}
+	if len(conf.Files()) != 3 {
+		t.Errorf("Expected 3 files, got %v", conf.Files())
+	}
+
+	_, _, err = LoadConfigIfModified(ctx, nil, lgr, dir, tag)
+	if !errors.Is(err, ErrNotModified) {
+		t.Errorf("Expected ErrNotModified for an unchanged directory, got %v", err)
+	}
+
+	writeConfigFiles(t, dir, map[string]string{"standup2.toml": standupFile})
+	_, _, err = LoadConfigIfModified(ctx, nil, lgr, dir, tag)
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "standup2.toml")+":3: rule standup: duplicate rule id or name: standup (also defined at "+filepath.Join(dir, "standup.toml")+":2)") {
+		t.Errorf("Expected a duplicate rule error naming both files, got %v", err)
+	}
+	os.Remove(filepath.Join(dir, "standup2.toml"))
+
+	writeConfigFiles(t, dir, map[string]string{"pager.toml": destinationsFile})
+	_, _, err = LoadConfigIfModified(ctx, nil, lgr, dir, tag)
+	if err == nil || !strings.Contains(err.Error(), "timezone is already set at "+filepath.Join(dir, "destinations.toml")) {
+		t.Errorf("Expected a conflicting timezone error, got %v", err)
+	}
+	os.Remove(filepath.Join(dir, "pager.toml"))
+
+	writeConfigFiles(t, dir, map[string]string{"retro.toml": strings.Replace(retroFile, `["pager"]`, `["missing"]`, 1)})
+	_, _, err = LoadConfigIfModified(ctx, nil, lgr, dir, tag)
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "retro.toml")+":5: rule retro: destination missing not found") {
+		t.Errorf("Expected a validation error naming retro.toml, got %v", err)
+	}
+}
+
+func TestLoadConfigReportsEveryFile(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"a.toml": destinationsFile,
+		"b.toml": destinationsFile,
+		"c.json": "{\n  \"rule\": [\n    {\"name\": }\n  ]\n}\n",
+		"d.toml": strings.Replace(standupFile, "subject =", "subjet =", 1),
+	})
+
+	_, _, err := LoadConfigIfModified(context.Background(), nil, lgr, dir, "")
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected ValidationErrors, got %v", err)
+	}
+
+	want := []struct {
+		file string
+		line int
+		key  string
+		msg  string
+	}{
+		{"c.json", 3, "", "invalid character"},
+		{"d.toml", 0, "", "unknown keys: rule.subjet"},
+		{"b.toml", 2, "timezone", "timezone is already set at " + filepath.Join(dir, "a.toml") + ":2"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Expected %d errors, got %d:\n%v", len(want), len(errs), err)
+	}
+	for i, w := range want {
+		got := errs[i]
+		if got.File != filepath.Join(dir, w.file) || got.Line != w.line || got.Key != w.key || !strings.Contains(got.Err.Error(), w.msg) {
+			t.Errorf("Expected error %d in %s at line %d for key %q containing %q, got %q", i, w.file, w.line, w.key, w.msg, got)
+		}
+	}
+}
+
+func TestLoadConfigInclude(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"config.toml":          "include = [\"destinations.toml\", \"teams/*.toml\"]\n",
+		"destinations.toml":    destinationsFile,
+		"teams/standup.toml":   standupFile,
+		"teams/retro.toml":     "include = [\"../destinations.toml\"]\n" + retroFile,
+		"teams/skip/skip.toml": "this isn't toml",
+	})
+
+	conf, tag, err := LoadConfigIfModified(ctx, nil, lgr, filepath.Join(dir, "config.toml"), "")
+	if err != nil {
+		t.Fatalf("LoadConfigIfModified() error = %v", err)
+	}
+	if len(conf.Rules) != 2 || len(conf.Destinations) != 1 {
+		t.Errorf("Expected 2 rules and 1 destination, got %d rules and %d destinations", len(conf.Rules), len(conf.Destinations))
+	}
+	if len(conf.Files()) != 4 {
+		t.Errorf("Expected 4 files, got %v", conf.Files())
+	}
+
+	writeConfigFiles(t, dir, map[string]string{"teams/standup.toml": "[[rule]\n"})
+	_, badTag, err := LoadConfigIfModified(ctx, nil, lgr, filepath.Join(dir, "config.toml"), tag)
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "teams", "standup.toml")) {
+		t.Errorf("Expected a decode error naming the included file, got %v", err)
+	}
+	if badTag == "" || badTag == tag {
+		t.Errorf("Expected a new tag for a changed included file, got %q", badTag)
+	}
+}
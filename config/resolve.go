@@ -0,0 +1,241 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// Config values may contain references such as ${env:SLACK_WEBHOOK},
+// ${ssm:/reminder/slack-webhook} or ${secretsmanager:reminder#webhook},
+// which are replaced with the referenced value when the config is loaded.
+// Resolved values are treated as secrets, see Config.Secrets.
+
+// Reference is a ${scheme:key} reference in a config value.
+type Reference struct {
+	Scheme string
+	Key    string
+}
+
+func (r Reference) String() string {
+	return r.Scheme + ":" + r.Key
+}
+
+// A Resolver looks up the value of a reference.
+type Resolver interface {
+	Resolve(ctx context.Context, ref Reference) (string, error)
+}
+
+// DefaultResolvers returns the resolvers for the reference schemes that
+// need no configuration, which is only env. The binary adds ssm and
+// secretsmanager.
+func DefaultResolvers() map[string]Resolver {
+	return map[string]Resolver{
+		"env": EnvResolver{},
+	}
+}
+
+var referenceRE = regexp.MustCompile(`\$\{([a-z]+):([^}]+)\}`)
+
+// resolveReferences replaces the references in every string in conf and
+// records the resolved values as secrets. A nil resolvers map leaves
+// references unresolved.
+func resolveReferences(ctx context.Context, conf *Config, resolvers map[string]Resolver) error {
+	if resolvers == nil {
+		return nil
+	}
+
+	resolved := make(map[Reference]string)
+	var walk func(v reflect.Value) error
+	walk = func(v reflect.Value) error {
+		switch v.Kind() {
+		case reflect.String:
+			s, err := resolveString(ctx, v.String(), resolvers, resolved)
+			if err != nil {
+				return err
+			}
+			v.SetString(s)
+		case reflect.Pointer:
+			if !v.IsNil() {
+				return walk(v.Elem())
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				err := walk(v.Index(i))
+				if err != nil {
+					return err
+				}
+			}
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				if !v.Type().Field(i).IsExported() {
+					continue
+				}
+				err := walk(v.Field(i))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	err := walk(reflect.ValueOf(conf).Elem())
+	if err != nil {
+		return err
+	}
+
+	conf.secrets = nil
+	for _, v := range resolved {
+		conf.secrets = append(conf.secrets, v)
+	}
+	sort.Strings(conf.secrets)
+	return nil
+}
+
+func resolveString(ctx context.Context, s string, resolvers map[string]Resolver, resolved map[Reference]string) (string, error) {
+	var resolveErr error
+	out := referenceRE.ReplaceAllStringFunc(s, func(m string) string {
+		if resolveErr != nil {
+			return m
+		}
+		sub := referenceRE.FindStringSubmatch(m)
+		ref := Reference{Scheme: sub[1], Key: sub[2]}
+
+		if v, ok := resolved[ref]; ok {
+			return v
+		}
+
+		r, ok := resolvers[ref.Scheme]
+		if !ok {
+			resolveErr = fmt.Errorf("resolve ${%s}: unknown reference type %q", ref, ref.Scheme)
+			return m
+		}
+		v, err := r.Resolve(ctx, ref)
+		if err != nil {
+			resolveErr = fmt.Errorf("resolve ${%s}: %w", ref, err)
+			return m
+		}
+		resolved[ref] = v
+		return v
+	})
+	return out, resolveErr
+}
+
+// EnvResolver resolves ${env:NAME} from the environment. Unset variables
+// are an error.
+type EnvResolver struct{}
+
+func (EnvResolver) Resolve(ctx context.Context, ref Reference) (string, error) {
+	v, ok := os.LookupEnv(ref.Key)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s not set", ref.Key)
+	}
+	return v, nil
+}
+
+// SSMResolver resolves ${ssm:/parameter/name} from SSM Parameter Store,
+// decrypting SecureString parameters.
+type SSMResolver struct {
+	Client *ssm.Client
+}
+
+func (r SSMResolver) Resolve(ctx context.Context, ref Reference) (string, error) {
+	decrypt := true
+	out, err := r.Client.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           &ref.Key,
+		WithDecryption: &decrypt,
+	})
+	if err != nil {
+		return "", fmt.Errorf("get ssm parameter: %w", err)
+	}
+	if out.Parameter == nil || out.Parameter.Value == nil {
+		return "", fmt.Errorf("ssm parameter %s has no value", ref.Key)
+	}
+	return *out.Parameter.Value, nil
+}
+
+// SecretsManagerResolver resolves ${secretsmanager:secret-id} to a secret's
+// string value, or ${secretsmanager:secret-id#key} to one key of a JSON
+// secret.
+type SecretsManagerResolver struct {
+	Client *secretsmanager.Client
+}
+
+func (r SecretsManagerResolver) Resolve(ctx context.Context, ref Reference) (string, error) {
+	id, key, hasKey := strings.Cut(ref.Key, "#")
+
+	out, err := r.Client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: &id,
+	})
+	if err != nil {
+		return "", fmt.Errorf("get secret: %w", err)
+	}
+	if out.SecretString == nil {
+		return "", fmt.Errorf("secret %s has no string value", id)
+	}
+	if !hasKey {
+		return *out.SecretString, nil
+	}
+	return jsonKey(*out.SecretString, key)
+}
+
+// jsonKey returns a string value from a JSON object. Other JSON values are
+// returned as JSON.
+func jsonKey(data, key string) (string, error) {
+	var obj map[string]json.RawMessage
+	err := json.Unmarshal([]byte(data), &obj)
+	if err != nil {
+		return "", fmt.Errorf("secret is not a JSON object")
+	}
+	raw, ok := obj[key]
+	if !ok {
+		return "", fmt.Errorf("secret has no key %q", key)
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, nil
+	}
+	return string(raw), nil
+}
+
+// FileResolver resolves references from a local JSON file mapping
+// references to values, for testing a config offline:
+//
+//	{
+//	  "ssm:/reminder/slack-webhook": "https://hooks.slack.com/services/T000/B000/XXXX",
+//	  "secretsmanager:reminder#webhook": "https://example.com/hook"
+//	}
+type FileResolver struct {
+	values map[string]string
+}
+
+// LoadFileResolver reads a FileResolver's values from path.
+func LoadFileResolver(path string) (*FileResolver, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read secrets file: %w", err)
+	}
+	r := &FileResolver{}
+	err = json.Unmarshal(data, &r.values)
+	if err != nil {
+		return nil, fmt.Errorf("decode secrets file: %w", err)
+	}
+	return r, nil
+}
+
+func (r *FileResolver) Resolve(ctx context.Context, ref Reference) (string, error) {
+	v, ok := r.values[ref.String()]
+	if !ok {
+		return "", fmt.Errorf("%s not found in secrets file", ref)
+	}
+	return v, nil
+}
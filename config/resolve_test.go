@@ -0,0 +1,98 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const resolveTestConfig = `
+[[destination]]
+id = "slack"
+type = "slack_webhook"
+webhook_url = "https://hooks.slack.com/services/${env:SLACK_PATH}"
+
+[[destination]]
+id = "sns"
+type = "sns"
+sns_arn = "${ssm:/reminder/sns-arn}"
+
+[[rule]]
+name = "standup"
+cron = "0 9 * * *"
+destinations = ["slack", "sns"]
+subject = "Standup in ${env:ROOM}"
+body = "Webhook ${env:SLACK_PATH}"
+`
+
+func TestResolveReferences(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	secretsPath := filepath.Join(dir, "secrets.json")
+	err := os.WriteFile(secretsPath, []byte(`{"ssm:/reminder/sns-arn": "arn:aws:sns:us-east-1:123456789012:reminders"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr, err := LoadFileResolver(secretsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader := NewLoader()
+	loader.Resolvers["ssm"] = fr
+
+	t.Setenv("SLACK_PATH", "T000/B000/XXXX")
+	t.Setenv("ROOM", "the big room")
+
+	path := filepath.Join(dir, "config.toml")
+	err = os.WriteFile(path, []byte(resolveTestConfig), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := loader.LoadConfig(ctx, nil, lgr, path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	if got := conf.Destinations[0].WebhookURL; got != "https://hooks.slack.com/services/T000/B000/XXXX" {
+		t.Errorf("Expected the env reference to be resolved, got %q", got)
+	}
+	if got := conf.Destinations[1].SNSARN; got != "arn:aws:sns:us-east-1:123456789012:reminders" {
+		t.Errorf("Expected the ssm reference to be resolved from the file, got %q", got)
+	}
+	if got := conf.Rules[0].Subject; got != "Standup in the big room" {
+		t.Errorf("Expected the subject reference to be resolved, got %q", got)
+	}
+	want := []string{"T000/B000/XXXX", "arn:aws:sns:us-east-1:123456789012:reminders", "the big room"}
+	if !reflect.DeepEqual(conf.Secrets(), want) {
+		t.Errorf("Expected secrets %v, got %v", want, conf.Secrets())
+	}
+
+	os.Unsetenv("ROOM")
+	_, err = loader.LoadConfig(ctx, nil, lgr, path)
+	if err == nil || !strings.Contains(err.Error(), "environment variable ROOM not set") {
+		t.Errorf("Expected an unset variable error, got %v", err)
+	}
+
+	delete(loader.Resolvers, "ssm")
+	t.Setenv("ROOM", "the big room")
+	_, err = loader.LoadConfig(ctx, nil, lgr, path)
+	if err == nil || !strings.Contains(err.Error(), `unknown reference type "ssm"`) {
+		t.Errorf("Expected an unknown reference type error, got %v", err)
+	}
+
+	loader.Resolvers = nil
+	conf, err = loader.LoadConfig(ctx, nil, lgr, path)
+	if err != nil {
+		t.Fatalf("LoadConfig() without resolvers error = %v", err)
+	}
+	if got := conf.Destinations[1].SNSARN; got != "${ssm:/reminder/sns-arn}" {
+		t.Errorf("Expected references to be left alone without resolvers, got %q", got)
+	}
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+// ParseRRule parses a DTSTART line followed by one or more RRULE, RDATE or
+// EXDATE lines. A DTSTART without a TZID or UTC suffix is interpreted in loc.
+func ParseRRule(expr string, loc *time.Location) (*rrule.Set, error) {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(expr), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "DTSTART") {
+		return nil, fmt.Errorf("rrule must start with a DTSTART line")
+	}
+
+	set, err := rrule.StrSliceToRRuleSetInLoc(lines, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	if set.GetRRule() == nil {
+		return nil, fmt.Errorf("rrule must contain an RRULE line")
+	}
+
+	return set, nil
+}
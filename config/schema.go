@@ -0,0 +1,174 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// schemaFields adds keywords to individual properties, keyed by
+// "Type.key". It mirrors the checks in validateConfig that a schema can
+// express.
+var schemaFields = map[string]map[string]any{
+	"Config.orphan_action": {"enum": []string{OrphanActionPrune, OrphanActionArchive}},
+	"Config.timezone":      {"description": "IANA timezone name, e.g. America/New_York"},
+
+	"Rule.cron":            {"description": "Cron expression, e.g. \"0 9 * * mon-fri\""},
+	"Rule.rrule":           {"description": "RFC 5545 recurrence rule starting with a DTSTART line"},
+	"Rule.start_date":      {"format": "date"},
+	"Rule.end_date":        {"format": "date"},
+	"Rule.max_occurrences": {"minimum": 0},
+	"Rule.repeat_max":      {"minimum": 0},
+	"Rule.escalate_after":  {"minimum": 0},
+	"Rule.calendar_policy": {"enum": []string{CalendarPolicySkip, CalendarPolicyNextBusinessDay, CalendarPolicyPreviousBusinessDay}},
+
+	"Destination.type": {"enum": []string{"sns", "slack_webhook", "ses", "log"}},
+
+	"QuietHours.start":  {"pattern": clockPattern},
+	"QuietHours.end":    {"pattern": clockPattern},
+	"QuietHours.days":   {"items": map[string]any{"type": "string", "enum": []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}},
+	"QuietHours.action": {"enum": []string{QuietHoursDefer, QuietHoursDrop}},
+
+	"Calendar.dates": {"items": map[string]any{"type": "string", "format": "date"}},
+
+	"Links.signing_key": {"minLength": minSigningKeyLen},
+}
+
+// schemaTypes adds keywords to whole objects, keyed by type name. Config
+// requires nothing since a config split across files may have files with
+// only rules or only destinations.
+var schemaTypes = map[string]map[string]any{
+	"Rule": {
+		"required": []string{"name", "destinations", "subject", "body"},
+		"oneOf": []any{
+			map[string]any{"required": []string{"cron"}},
+			map[string]any{"required": []string{"rrule"}},
+			map[string]any{"required": []string{"every"}},
+		},
+		"dependentRequired": map[string]any{
+			"every":                 []string{"anchor"},
+			"anchor":                []string{"every"},
+			"repeat_every":          []string{"repeat_max"},
+			"repeat_max":            []string{"repeat_every"},
+			"escalate_destinations": []string{"repeat_every", "escalate_after"},
+		},
+	},
+	"Destination": {
+		"required": []string{"id", "type"},
+		"allOf": []any{
+			destinationRequires("sns", "sns_arn"),
+			destinationRequires("slack_webhook", "webhook_url"),
+			destinationRequires("ses", "from_email", "to_emails"),
+		},
+	},
+	"QuietHours": {
+		"required": []string{"start", "end"},
+	},
+	"Calendar": {
+		"required": []string{"name"},
+		"anyOf": []any{
+			map[string]any{"required": []string{"dates"}},
+			map[string]any{"required": []string{"file"}},
+		},
+	},
+	"Links": {
+		"required": []string{"base_url", "signing_key"},
+	},
+}
+
+// durationPattern matches what Duration decodes, less negative durations,
+// which validateConfig rejects.
+const (
+	clockPattern    = `^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	durationPattern = `^\+?([0-9]+d|0|(([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|μs|ms|s|m|h))+)$`
+)
+
+func destinationRequires(typ string, keys ...string) map[string]any {
+	return map[string]any{
+		"if": map[string]any{
+			"properties": map[string]any{"type": map[string]any{"const": typ}},
+		},
+		"then": map[string]any{"required": keys},
+	}
+}
+
+var (
+	durationType = reflect.TypeOf(Duration{})
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// Schema returns a JSON Schema for config files. It applies to all the
+// config formats, so editors can validate and complete TOML, YAML and JSON
+// configs alike.
+func Schema() map[string]any {
+	defs := make(map[string]any)
+	root := schemaObject(reflect.TypeOf(Config{}), defs)
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["title"] = "lambda-reminder config"
+	root["$defs"] = defs
+	return root
+}
+
+func schemaFor(t reflect.Type, defs map[string]any) map[string]any {
+	switch t {
+	case durationType:
+		return map[string]any{
+			"type":        "string",
+			"pattern":     durationPattern,
+			"description": "Duration such as 90m, 36h or 10d",
+		}
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaFor(t.Elem(), defs)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), defs)}
+	case reflect.Struct:
+		if _, ok := defs[t.Name()]; !ok {
+			// Reserve the name first in case the type refers to itself
+			defs[t.Name()] = nil
+			defs[t.Name()] = schemaObject(t, defs)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+func schemaObject(t reflect.Type, defs map[string]any) map[string]any {
+	props := make(map[string]any)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		key, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+
+		prop := schemaFor(f.Type, defs)
+		for k, v := range schemaFields[t.Name()+"."+key] {
+			prop[k] = v
+		}
+		props[key] = prop
+	}
+
+	obj := map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+	for k, v := range schemaTypes[t.Name()] {
+		obj[k] = v
+	}
+	return obj
+}
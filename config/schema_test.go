@@ -0,0 +1,164 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+func TestSchema(t *testing.T) {
+	schema := Schema()
+
+	_, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatalf("Expected the schema to marshal, got %v", err)
+	}
+
+	defs := schema["$defs"].(map[string]any)
+	objects := map[string]map[string]any{"Config": schema}
+	for name, def := range defs {
+		objects[name] = def.(map[string]any)
+	}
+
+	// Every TOML key must be in the schema, under the same name
+	for name, typ := range map[string]reflect.Type{
+		"Config":      reflect.TypeOf(Config{}),
+		"Rule":        reflect.TypeOf(Rule{}),
+		"Destination": reflect.TypeOf(Destination{}),
+		"QuietHours":  reflect.TypeOf(QuietHours{}),
+		"Calendar":    reflect.TypeOf(Calendar{}),
+		"Links":       reflect.TypeOf(Links{}),
+	} {
+		obj, ok := objects[name]
+		if !ok {
+			t.Errorf("Expected a schema for %s", name)
+			continue
+		}
+		props := obj["properties"].(map[string]any)
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			key, _, _ := strings.Cut(f.Tag.Get("toml"), ",")
+			if _, ok := props[key]; !ok {
+				t.Errorf("Expected %s.%s in the schema", name, key)
+			}
+		}
+	}
+
+	// Extra keywords must refer to real properties
+	for field := range schemaFields {
+		typ, key, _ := strings.Cut(field, ".")
+		obj, ok := objects[typ]
+		if !ok {
+			t.Errorf("schemaFields entry %s refers to unknown type %s", field, typ)
+			continue
+		}
+		if _, ok := obj["properties"].(map[string]any)[key]; !ok {
+			t.Errorf("schemaFields entry %s refers to unknown key %s", field, key)
+		}
+	}
+	for typ := range schemaTypes {
+		if _, ok := objects[typ]; !ok {
+			t.Errorf("schemaTypes entry refers to unknown type %s", typ)
+		}
+	}
+}
+
+func compileSchema(t *testing.T) *jsonschema.Schema {
+	t.Helper()
+
+	data, err := json.Marshal(Schema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := jsonschema.UnmarshalJSON(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := jsonschema.NewCompiler()
+	err = c.AddResource("config.schema.json", doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := c.Compile("config.schema.json")
+	if err != nil {
+		t.Fatalf("Expected the schema to compile, got %v", err)
+	}
+	return schema
+}
+
+func TestSchemaFixtures(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	schema := compileSchema(t)
+
+	tests := []struct {
+		name  string
+		body  string
+		valid bool
+	}{
+		{
+			name:  "valid",
+			body:  formatTestJSON,
+			valid: true,
+		},
+		{
+			name:  "unknown key",
+			body:  strings.Replace(formatTestJSON, `"subject": "Standup"`, `"subjet": "Standup"`, 1),
+			valid: false,
+		},
+		{
+			name:  "bad duration",
+			body:  strings.Replace(formatTestJSON, `"repeat_every": "30m"`, `"repeat_every": "30 minutes"`, 1),
+			valid: false,
+		},
+	}
+
+	for _, tt := range tests {
+		inst, err := jsonschema.UnmarshalJSON(strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		schemaErr := schema.Validate(inst)
+
+		path := filepath.Join(t.TempDir(), "config.json")
+		err = os.WriteFile(path, []byte(tt.body), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, loadErr := LoadConfig(context.Background(), nil, lgr, path)
+
+		if tt.valid && (schemaErr != nil || loadErr != nil) {
+			t.Errorf("%s: Expected the config to be valid, got schema error %v, load error %v", tt.name, schemaErr, loadErr)
+		}
+		if !tt.valid && (schemaErr == nil || loadErr == nil) {
+			t.Errorf("%s: Expected the config to be invalid, got schema error %v, load error %v", tt.name, schemaErr, loadErr)
+		}
+	}
+}
+
+func TestDurationPattern(t *testing.T) {
+	pattern := regexp.MustCompile(durationPattern)
+
+	for _, s := range []string{
+		"0", "+0", "0s", "90m", "1h30m", "1.5h", ".5h", "1.h", "2µs", "2μs",
+		"10d", "0d", "+3d", "-5m", "-3d", "5", "d", "h", ".h", "3.5d", "1h-5m", "",
+	} {
+		var d Duration
+		err := d.UnmarshalText([]byte(s))
+		loads := err == nil && d.Duration >= 0
+		if matches := pattern.MatchString(s); matches != loads {
+			t.Errorf("Duration %q: schema matches = %t, loader accepts = %t", s, matches, loads)
+		}
+	}
+}
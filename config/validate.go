@@ -0,0 +1,535 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/adhocore/gronx"
+)
+
+// ValidationError is one problem found in a config.
+type ValidationError struct {
+	// File and Line locate the problem. Line is zero when it isn't
+	// known, which is usually the case outside TOML files.
+	File string
+	Line int
+
+	// Rule, Destination or Calendar names the item with the problem, if
+	// any, and Key the setting within it or at the top level, e.g. "cron"
+	// or "quiet_hours.start".
+	Rule        string
+	Destination string
+	Calendar    string
+	Key         string
+
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File)
+		if e.Line > 0 {
+			fmt.Fprintf(&b, ":%d", e.Line)
+		}
+		b.WriteString(": ")
+	}
+	switch {
+	case e.Rule != "":
+		fmt.Fprintf(&b, "rule %s: ", e.Rule)
+	case e.Destination != "":
+		fmt.Fprintf(&b, "destination %s: ", e.Destination)
+	case e.Calendar != "":
+		fmt.Fprintf(&b, "calendar %s: ", e.Calendar)
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationErrors is every problem found in a config, in config order.
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = "\n  " + e.Error()
+	}
+	return fmt.Sprintf("%d problems:%s", len(errs), strings.Join(msgs, ""))
+}
+
+func (errs ValidationErrors) Unwrap() []error {
+	out := make([]error, len(errs))
+	for i, e := range errs {
+		out[i] = e
+	}
+	return out
+}
+
+// location is where a config item or setting was defined.
+type location struct {
+	file string
+	line int
+	// keys are the lines of the item's keys, e.g. "cron" or
+	// "quiet_hours.start". They're only known for TOML files.
+	keys map[string]int
+}
+
+// lineOf returns the line key was set on, falling back to its enclosing
+// table and then to the item itself.
+func (l location) lineOf(key string) int {
+	for key != "" {
+		if line, ok := l.keys[key]; ok {
+			return line
+		}
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			break
+		}
+		key = key[:i]
+	}
+	return l.line
+}
+
+func (l location) String() string {
+	if l.line > 0 {
+		return fmt.Sprintf("%s:%d", l.file, l.line)
+	}
+	return l.file
+}
+
+// tomlPositions are the lines of the keys and tables in a TOML file.
+type tomlPositions struct {
+	// top holds top level keys and the keys of top level tables, such as
+	// "links" and "links.base_url".
+	top map[string]int
+	// items holds the [[rule]], [[destination]] and [[calendar]] tables in
+	// order, by table name.
+	items map[string][]location
+}
+
+var (
+	tomlKeyRE   = regexp.MustCompile(`^([A-Za-z0-9_-]+(?:\s*\.\s*[A-Za-z0-9_-]+)*|"[^"]*")\s*=\s*(.*)$`)
+	tomlTableRE = regexp.MustCompile(`^(\[\[?)\s*([A-Za-z0-9_.-]+)\s*\]\]?`)
+)
+
+// scanTOML finds the lines of the tables and keys in a TOML file. It only
+// understands as much TOML as the config uses: tables, arrays of tables,
+// dotted keys and multi-line strings.
+func scanTOML(name string, data []byte) *tomlPositions {
+	pos := &tomlPositions{
+		top:   make(map[string]int),
+		items: make(map[string][]location),
+	}
+
+	var (
+		cur       *location // the current item table, if any
+		prefix    string    // prefix for keys in a sub-table
+		multiline string    // delimiter of the multi-line string being skipped
+	)
+	for i, line := range strings.Split(string(data), "\n") {
+		n := i + 1
+		trimmed := strings.TrimSpace(line)
+
+		if multiline != "" {
+			if strings.Contains(trimmed, multiline) {
+				multiline = ""
+			}
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if m := tomlTableRE.FindStringSubmatch(trimmed); m != nil {
+			table := m[2]
+			switch {
+			case m[1] == "[[" && (table == "rule" || table == "destination" || table == "calendar"):
+				pos.items[table] = append(pos.items[table], location{
+					file: name,
+					line: n,
+					keys: make(map[string]int),
+				})
+				cur = &pos.items[table][len(pos.items[table])-1]
+				prefix = ""
+			case cur != nil && strings.Contains(table, "."):
+				// A sub-table of the current item, e.g. [destination.quiet_hours]
+				_, sub, _ := strings.Cut(table, ".")
+				cur.keys[sub] = n
+				prefix = sub + "."
+			default:
+				cur = nil
+				pos.top[table] = n
+				prefix = table + "."
+			}
+			continue
+		}
+
+		m := tomlKeyRE.FindStringSubmatch(trimmed)
+		if m == nil {
+			continue
+		}
+		key := prefix + strings.Trim(strings.ReplaceAll(m[1], " ", ""), `"`)
+		keys := pos.top
+		if cur != nil {
+			keys = cur.keys
+		}
+		if _, ok := keys[key]; !ok {
+			keys[key] = n
+		}
+
+		value := m[2]
+		for _, delim := range []string{`"""`, `'''`} {
+			if strings.HasPrefix(value, delim) && !strings.Contains(value[len(delim):], delim) {
+				multiline = delim
+			}
+		}
+	}
+
+	return pos
+}
+
+// item returns the location of the i'th table named table.
+func (p *tomlPositions) item(table string, i int) (location, bool) {
+	if p == nil || i >= len(p.items[table]) {
+		return location{}, false
+	}
+	return p.items[table][i], true
+}
+
+// validator collects problems found in a config.
+type validator struct {
+	errs ValidationErrors
+}
+
+// report records err for key in the item at loc. e identifies the item.
+func (v *validator) report(loc location, key string, e ValidationError, err error) {
+	e.File = loc.file
+	e.Line = loc.lineOf(key)
+	e.Key = key
+	e.Err = err
+	v.errs = append(v.errs, &e)
+}
+
+// setting reports a problem with a top level setting.
+func (v *validator) setting(conf *Config, key string, err error) {
+	top, _, _ := strings.Cut(key, ".")
+	v.report(conf.locs[top], key, ValidationError{}, err)
+}
+
+// validateConfig checks the whole config, returning every problem found as
+// ValidationErrors.
+func validateConfig(conf *Config) error {
+	var v validator
+
+	if len(conf.Rules) == 0 {
+		v.setting(conf, "rule", fmt.Errorf("at least one rule must be defined"))
+	}
+
+	if len(conf.Destinations) == 0 {
+		v.setting(conf, "destination", fmt.Errorf("at least one destination must be defined"))
+	}
+
+	destMap := make(map[string]bool)
+	destLocs := make(map[string]location)
+	for _, dest := range conf.Destinations {
+		id := ValidationError{Destination: dest.ID}
+		report := func(key string, err error) {
+			v.report(dest.loc, key, id, err)
+		}
+
+		if dest.ID == "" {
+			report("id", fmt.Errorf("destination id cannot be empty"))
+		} else if prev, dup := destLocs[dest.ID]; dup {
+			report("id", fmt.Errorf("duplicate destination id: %s (also defined at %s)", dest.ID, prev))
+		} else {
+			destLocs[dest.ID] = dest.loc
+		}
+		destMap[dest.ID] = true
+
+		validateDestination(&dest, report)
+	}
+
+	calendarMap := make(map[string]bool)
+	calendarLocs := make(map[string]location)
+	for _, cal := range conf.Calendars {
+		id := ValidationError{Calendar: cal.Name}
+		report := func(key string, err error) {
+			v.report(cal.loc, key, id, err)
+		}
+
+		if cal.Name == "" {
+			report("name", fmt.Errorf("calendar name cannot be empty"))
+		} else if prev, dup := calendarLocs[cal.Name]; dup {
+			report("name", fmt.Errorf("duplicate calendar name: %s (also defined at %s)", cal.Name, prev))
+		} else {
+			calendarLocs[cal.Name] = cal.loc
+		}
+		calendarMap[cal.Name] = true
+
+		if len(cal.Dates) == 0 && cal.File == "" {
+			report("dates", fmt.Errorf("dates or file is required"))
+		}
+		for _, d := range cal.Dates {
+			if _, err := time.Parse(dateFormat, d); err != nil {
+				report("dates", fmt.Errorf("invalid date %q, expected YYYY-MM-DD", d))
+			}
+		}
+	}
+
+	stateKeys := make(map[string]location)
+	names := make(map[string]location)
+	for _, rule := range conf.Rules {
+		id := ValidationError{Rule: rule.Name}
+		report := func(key string, err error) {
+			v.report(rule.loc, key, id, err)
+		}
+
+		validateRule(&rule, destMap, calendarMap, report)
+
+		key := rule.StateKey()
+		keyField := "name"
+		if rule.ID != "" {
+			keyField = "id"
+		}
+		if prev, dup := stateKeys[key]; dup && key != "" {
+			report(keyField, fmt.Errorf("duplicate rule id or name: %s (also defined at %s)", key, prev))
+		} else if prev, dup := names[rule.Name]; dup && rule.Name != "" {
+			report("name", fmt.Errorf("duplicate rule name: %s (also defined at %s)", rule.Name, prev))
+		}
+		if _, ok := stateKeys[key]; !ok {
+			stateKeys[key] = rule.loc
+		}
+		if _, ok := names[rule.Name]; !ok {
+			names[rule.Name] = rule.loc
+		}
+	}
+
+	switch conf.OrphanAction {
+	case "", OrphanActionPrune, OrphanActionArchive:
+	default:
+		v.setting(conf, "orphan_action", fmt.Errorf("invalid orphan_action: %s", conf.OrphanAction))
+	}
+
+	if conf.OrphanGracePeriod.Duration < 0 {
+		v.setting(conf, "orphan_grace_period", fmt.Errorf("orphan_grace_period cannot be negative"))
+	}
+
+	if conf.Timezone != "" {
+		_, err := time.LoadLocation(conf.Timezone)
+		if err != nil {
+			v.setting(conf, "timezone", fmt.Errorf("invalid timezone: %w", err))
+		}
+	}
+
+	for _, destID := range conf.ErrorDestinations {
+		if !destMap[destID] {
+			v.setting(conf, "error_destinations", fmt.Errorf("error_destinations: unknown destination %s", destID))
+		}
+	}
+
+	if conf.Links != nil {
+		validateLinks(conf.Links, func(key string, err error) {
+			v.setting(conf, "links."+key, fmt.Errorf("links: %w", err))
+		})
+	}
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+func validateLinks(l *Links, report func(key string, err error)) {
+	u, err := url.Parse(l.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		report("base_url", fmt.Errorf("base_url must be an absolute http or https url"))
+	}
+
+	if len(l.SigningKey) < minSigningKeyLen {
+		report("signing_key", fmt.Errorf("signing_key must be at least %d characters", minSigningKeyLen))
+	}
+
+	if l.Expiry.Duration < 0 {
+		report("expiry", fmt.Errorf("expiry cannot be negative"))
+	}
+}
+
+func validateDestination(dest *Destination, report func(key string, err error)) {
+	switch dest.Type {
+	case "sns":
+		if dest.SNSARN == "" {
+			report("sns_arn", fmt.Errorf("sns_arn is required for sns destination"))
+		}
+	case "slack_webhook":
+		if dest.WebhookURL == "" {
+			report("webhook_url", fmt.Errorf("webhook_url is required for slack_webhook destination"))
+		}
+	case "ses":
+		if dest.FromEmail == "" {
+			report("from_email", fmt.Errorf("from_email is required for ses destination"))
+		}
+		if len(dest.ToEmails) == 0 {
+			report("to_emails", fmt.Errorf("to_emails is required for ses destination"))
+		}
+	case "log":
+	default:
+		report("type", fmt.Errorf("unsupported destination type: %s", dest.Type))
+	}
+
+	if dest.QuietHours != nil {
+		validateQuietHours(dest.QuietHours, func(key string, err error) {
+			report("quiet_hours."+key, fmt.Errorf("quiet_hours: %w", err))
+		})
+	}
+}
+
+func validateQuietHours(q *QuietHours, report func(key string, err error)) {
+	if _, err := time.Parse(clockFormat, q.Start); err != nil {
+		report("start", fmt.Errorf("invalid start %q, expected HH:MM", q.Start))
+	}
+	if _, err := time.Parse(clockFormat, q.End); err != nil {
+		report("end", fmt.Errorf("invalid end %q, expected HH:MM", q.End))
+	}
+	if q.Timezone != "" {
+		if _, err := time.LoadLocation(q.Timezone); err != nil {
+			report("timezone", fmt.Errorf("invalid timezone: %w", err))
+		}
+	}
+	for _, d := range q.Days {
+		if _, ok := weekdays[strings.ToLower(d)]; !ok {
+			report("days", fmt.Errorf("invalid day %q", d))
+		}
+	}
+	switch q.Action {
+	case "", QuietHoursDefer, QuietHoursDrop:
+	default:
+		report("action", fmt.Errorf("invalid action: %s", q.Action))
+	}
+}
+
+func validateRule(rule *Rule, destMap, calendarMap map[string]bool, report func(key string, err error)) {
+	if rule.Name == "" {
+		report("name", fmt.Errorf("rule name cannot be empty"))
+	}
+	var schedules int
+	for _, set := range []bool{rule.Cron != "", rule.RRule != "", rule.Every.Duration != 0} {
+		if set {
+			schedules++
+		}
+	}
+	if schedules == 0 {
+		report("cron", fmt.Errorf("cron expression cannot be empty"))
+	}
+	if schedules > 1 {
+		report("", fmt.Errorf("only one of cron, rrule or every can be set"))
+	}
+	if rule.Cron != "" && !gronx.New().IsValid(rule.Cron) {
+		report("cron", fmt.Errorf("invalid cron expression %q", rule.Cron))
+	}
+	if rule.Every.Duration < 0 {
+		report("every", fmt.Errorf("every must be positive"))
+	}
+	if rule.Every.Duration > 0 && rule.Anchor.IsZero() {
+		report("every", fmt.Errorf("anchor is required with every"))
+	}
+	if rule.Every.Duration == 0 && !rule.Anchor.IsZero() {
+		report("anchor", fmt.Errorf("anchor can only be used with every"))
+	}
+	if rule.RRule != "" {
+		if _, err := ParseRRule(rule.RRule, time.UTC); err != nil {
+			report("rrule", fmt.Errorf("invalid rrule: %w", err))
+		}
+	}
+	if len(rule.Destinations) == 0 {
+		report("destinations", fmt.Errorf("at least one destination must be specified"))
+	}
+	if rule.Subject == "" {
+		report("subject", fmt.Errorf("subject cannot be empty"))
+	} else if _, err := template.New("subject").Parse(rule.Subject); err != nil {
+		report("subject", fmt.Errorf("invalid subject template: %w", err))
+	}
+	if rule.Body == "" {
+		report("body", fmt.Errorf("body cannot be empty"))
+	} else if _, err := template.New("body").Parse(rule.Body); err != nil {
+		report("body", fmt.Errorf("invalid body template: %w", err))
+	}
+	if rule.MaxOccurrences < 0 {
+		report("max_occurrences", fmt.Errorf("max_occurrences cannot be negative"))
+	}
+
+	if rule.RepeatEvery.Duration < 0 {
+		report("repeat_every", fmt.Errorf("repeat_every cannot be negative"))
+	}
+	if rule.RepeatEvery.Duration > 0 && rule.RepeatMax <= 0 {
+		report("repeat_max", fmt.Errorf("repeat_max is required with repeat_every"))
+	}
+	if rule.RepeatEvery.Duration == 0 && rule.RepeatMax != 0 {
+		report("repeat_max", fmt.Errorf("repeat_max can only be used with repeat_every"))
+	}
+	if rule.EscalateAfter < 0 {
+		report("escalate_after", fmt.Errorf("escalate_after cannot be negative"))
+	}
+	if len(rule.EscalateDestinations) > 0 && rule.RepeatEvery.Duration == 0 {
+		report("escalate_destinations", fmt.Errorf("escalate_destinations can only be used with repeat_every"))
+	}
+	if len(rule.EscalateDestinations) > 0 && rule.EscalateAfter == 0 {
+		report("escalate_destinations", fmt.Errorf("escalate_after is required with escalate_destinations"))
+	}
+	for _, destID := range rule.EscalateDestinations {
+		if !destMap[destID] {
+			report("escalate_destinations", fmt.Errorf("escalation destination %s not found", destID))
+		}
+	}
+
+	if rule.Jitter.Duration < 0 {
+		report("jitter", fmt.Errorf("jitter cannot be negative"))
+	}
+
+	for _, name := range rule.SkipCalendars {
+		if !calendarMap[name] {
+			report("skip_calendars", fmt.Errorf("calendar %s not found", name))
+		}
+	}
+
+	switch rule.CalendarPolicy {
+	case "", CalendarPolicySkip, CalendarPolicyNextBusinessDay, CalendarPolicyPreviousBusinessDay:
+	default:
+		report("calendar_policy", fmt.Errorf("invalid calendar_policy: %s", rule.CalendarPolicy))
+	}
+
+	for _, destID := range rule.Destinations {
+		if !destMap[destID] {
+			report("destinations", fmt.Errorf("destination %s not found", destID))
+		}
+	}
+
+	var start, end time.Time
+	if rule.StartDate != "" {
+		var err error
+		start, err = time.Parse(dateFormat, rule.StartDate)
+		if err != nil {
+			report("start_date", fmt.Errorf("invalid start_date %q, expected YYYY-MM-DD", rule.StartDate))
+		}
+	}
+	if rule.EndDate != "" {
+		var err error
+		end, err = time.Parse(dateFormat, rule.EndDate)
+		if err != nil {
+			report("end_date", fmt.Errorf("invalid end_date %q, expected YYYY-MM-DD", rule.EndDate))
+		}
+	}
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		report("end_date", fmt.Errorf("end_date %s is before start_date %s", rule.EndDate, rule.StartDate))
+	}
+}
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const invalidTestConfig = `timezone = "Mars/Olympus_Mons"
+
+[[destination]]
+id = "pager"
+type = "log"
+
+[destination.quiet_hours]
+start = "25:00"
+end = "07:00"
+
+[[rule]]
+id = "standup-1"
+name = "standup"
+cron = "0 25 * * *"
+destinations = ["pager"]
+subject = "Standup"
+body = """
+Time for standup.
+cron = "not a key"
+"""
+
+[[rule]]
+id = "standup-2"
+name = "standup"
+cron = "0 10 * * *"
+destinations = ["pager", "missing"]
+body = "Time for standup"
+`
+
+func TestValidationErrors(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(path, []byte(invalidTestConfig), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadConfig(context.Background(), nil, lgr, path)
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected ValidationErrors, got %v", err)
+	}
+
+	want := []ValidationError{
+		{Line: 8, Destination: "pager", Key: "quiet_hours.start"},
+		{Line: 14, Rule: "standup", Key: "cron"},
+		{Line: 22, Rule: "standup", Key: "subject"},
+		{Line: 26, Rule: "standup", Key: "destinations"},
+		{Line: 24, Rule: "standup", Key: "name"},
+		{Line: 1, Key: "timezone"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Expected %d errors, got %d:\n%v", len(want), len(errs), err)
+	}
+	for i, w := range want {
+		got := errs[i]
+		if got.File != path || got.Line != w.Line || got.Rule != w.Rule || got.Destination != w.Destination || got.Key != w.Key {
+			t.Errorf("Expected error %d at line %d for rule %q destination %q key %q, got %q", i, w.Line, w.Rule, w.Destination, w.Key, got)
+		}
+	}
+}
+
+func TestScanTOML(t *testing.T) {
+	pos := scanTOML("config.toml", []byte(invalidTestConfig))
+
+	if got := pos.top["timezone"]; got != 1 {
+		t.Errorf("Expected timezone on line 1, got %d", got)
+	}
+	if n := len(pos.items["rule"]); n != 2 {
+		t.Fatalf("Expected 2 rules, got %d", n)
+	}
+
+	rule := pos.items["rule"][0]
+	if rule.line != 11 || rule.keys["cron"] != 14 || rule.keys["body"] != 17 {
+		t.Errorf("Expected the first rule on line 11 with cron on 14 and body on 17, got %+v", rule)
+	}
+
+	dest, _ := pos.item("destination", 0)
+	if got := dest.lineOf("quiet_hours.end"); got != 9 {
+		t.Errorf("Expected quiet_hours.end on line 9, got %d", got)
+	}
+	if got := dest.lineOf("quiet_hours.days"); got != 7 {
+		t.Errorf("Expected a missing quiet_hours key to fall back to its table on line 7, got %d", got)
+	}
+}
+
+func TestValidateEscalation(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	tests := []struct {
+		name    string
+		rule    string
+		wantErr string
+	}{
+		{
+			name:    "escalate_after missing",
+			rule:    "repeat_every = \"1h\"\nrepeat_max = 3\nescalate_destinations = [\"manager\"]",
+			wantErr: "escalate_after is required with escalate_destinations",
+		},
+		{
+			name:    "repeat_every missing",
+			rule:    "escalate_after = 1\nescalate_destinations = [\"manager\"]",
+			wantErr: "escalate_destinations can only be used with repeat_every",
+		},
+		{
+			name: "valid",
+			rule: "repeat_every = \"1h\"\nrepeat_max = 3\nescalate_after = 1\nescalate_destinations = [\"manager\"]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := `
+[[destination]]
+id = "pager"
+type = "log"
+
+[[destination]]
+id = "manager"
+type = "log"
+
+[[rule]]
+name = "renew_cert"
+cron = "0 9 1 * *"
+destinations = ["pager"]
+subject = "Renew the certificate"
+body = "It expires soon"
+` + tt.rule + "\n"
+
+			path := filepath.Join(t.TempDir(), "config.toml")
+			err := os.WriteFile(path, []byte(conf), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = LoadConfig(context.Background(), nil, lgr, path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			var errs ValidationErrors
+			if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Key != "escalate_destinations" || !strings.Contains(errs[0].Error(), tt.wantErr) {
+				t.Errorf("Expected one escalate_destinations error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateRRule(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	tests := []struct {
+		name    string
+		rrule   string
+		wantErr string
+	}{
+		{
+			name:    "missing DTSTART",
+			rrule:   "RRULE:FREQ=WEEKLY;BYDAY=MO",
+			wantErr: "rrule must start with a DTSTART line",
+		},
+		{
+			name:    "bad frequency",
+			rrule:   "DTSTART:20240101T090000Z\nRRULE:FREQ=SOMETIMES",
+			wantErr: "invalid rrule",
+		},
+		{
+			name:    "missing RRULE",
+			rrule:   "DTSTART:20240101T090000Z",
+			wantErr: "rrule must contain an RRULE line",
+		},
+		{
+			name:  "valid",
+			rrule: "DTSTART:20240101T090000Z\nRRULE:FREQ=WEEKLY;BYDAY=MO",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := fmt.Sprintf(`
+[[destination]]
+id = "pager"
+type = "log"
+
+[[rule]]
+name = "weekly"
+rrule = %q
+destinations = ["pager"]
+subject = "Weekly"
+body = "Weekly"
+`, tt.rrule)
+
+			path := filepath.Join(t.TempDir(), "config.toml")
+			err := os.WriteFile(path, []byte(conf), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = LoadConfig(context.Background(), nil, lgr, path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			var errs ValidationErrors
+			if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Key != "rrule" || errs[0].Line != 8 || !strings.Contains(errs[0].Error(), tt.wantErr) {
+				t.Errorf("Expected one rrule error on line 8 containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// idleCheckInterval is the longest the local daemon sleeps without
+	// checking the config for changes. It also bounds how late a rule that
+	// becomes active on its start date is noticed.
+	idleCheckInterval = time.Minute
+
+	minBackoff = 5 * time.Second
+	maxBackoff = 5 * time.Minute
+
+	shutdownTimeout = 10 * time.Second
+)
+
+// localRunLoop runs the handler whenever something is due until ctx is
+// done. Between runs it sleeps until the earliest due time, waking early if
+// the config changes. A local config file is watched and hot reloaded; an
+// invalid edit is logged and the last known-good config stays in use.
+// Errors are retried with exponential backoff rather than stopping the
+// daemon. A run in progress when ctx is cancelled is allowed to finish
+// sending and save state.
+func (h *handler) localRunLoop(ctx context.Context) error {
+	if *listenAddr != "" {
+		srv := &http.Server{Addr: *listenAddr, Handler: h}
+		go func() {
+			h.lgr.Info("serving link endpoint", "addr", *listenAddr)
+			err := srv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				h.lgr.Error("link endpoint error", "err", err)
+				os.Exit(1)
+			}
+		}()
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			srv.Shutdown(shutdownCtx)
+		}()
+	}
+
+	if *configPath != "" {
+		err := h.watchConfig(ctx)
+		if err != nil {
+			h.lgr.Warn("can't watch config, polling for changes instead", "err", err)
+		}
+	}
+
+	var backoff time.Duration
+	for {
+		fingerprint := h.configFingerprint(ctx)
+
+		var wake time.Time
+		result, err := h.run(context.WithoutCancel(ctx), runOptions{})
+		if err != nil {
+			backoff = min(max(2*backoff, minBackoff), maxBackoff)
+			wake = h.clock.Now().Add(backoff)
+			h.lgr.Error("handler error, retrying", "err", err, "backoff", backoff)
+		} else {
+			backoff = 0
+			wake = result.NextWake
+			h.lgr.Debug("sleeping until next due time", "next_wake", wake)
+		}
+
+		if !h.sleepUntil(ctx, wake, fingerprint) {
+			h.lgr.Info("shutting down")
+			return nil
+		}
+	}
+}
+
+// sleepUntil waits until wake, or indefinitely if wake is zero, returning
+// early if the config changes. A watched config signals reloads directly;
+// otherwise it is checked every idleCheckInterval. It returns false if ctx is
+// done.
+func (h *handler) sleepUntil(ctx context.Context, wake time.Time, fingerprint string) bool {
+	for {
+		if ctx.Err() != nil {
+			return false
+		}
+
+		d := idleCheckInterval
+		if !wake.IsZero() {
+			remaining := wake.Sub(h.clock.Now())
+			if remaining <= 0 {
+				return true
+			}
+			d = min(remaining, idleCheckInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-h.configReloaded:
+			h.lgr.Info("config changed, running early")
+			return true
+		case <-h.clock.After(d):
+		}
+
+		if !wake.IsZero() && !h.clock.Now().Before(wake) {
+			return true
+		}
+
+		if h.isWatchingConfig() {
+			continue
+		}
+
+		if fp := h.configFingerprint(ctx); fp != fingerprint {
+			h.lgr.Info("config changed, running early")
+			return true
+		}
+	}
+}
+
+// configFingerprint returns the config's fingerprint, or an empty string if
+// it can't be read; the next run reports the underlying error.
+func (h *handler) configFingerprint(ctx context.Context) string {
+	fp, err := h.configLoader().Fingerprint(ctx, h.s3Client, *configPath)
+	if err != nil {
+		h.lgr.Warn("check config for changes", "err", err)
+		return ""
+	}
+	return fp
+}
+
+func (h *handler) isWatchingConfig() bool {
+	h.confMu.Lock()
+	defer h.confMu.Unlock()
+	return h.watchingConfig
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/psanford/lambda-reminder/config"
+	"github.com/psanford/lambda-reminder/notifications"
+	"github.com/psanford/lambda-reminder/scheduler"
+	"github.com/psanford/lambda-reminder/state"
+)
+
+// messageSender sends rendered messages. It is satisfied by
+// notifications.NotificationSender and by recordingSender for dry runs.
+type messageSender interface {
+	SendMessage(ctx context.Context, msg notifications.Message, destinations []config.Destination) error
+	GetDestinationsForRule(rule config.Rule, allDestinations []config.Destination) []config.Destination
+}
+
+// recordedMessage is a message a dry run would have sent.
+type recordedMessage struct {
+	Rule     string                  `json:"rule"`
+	Subject  string                  `json:"subject"`
+	Payloads []notifications.Payload `json:"payloads"`
+}
+
+// recordingSender records messages instead of sending them.
+type recordingSender struct {
+	*notifications.NotificationSender
+	messages []recordedMessage
+}
+
+func (r *recordingSender) SendMessage(ctx context.Context, msg notifications.Message, destinations []config.Destination) error {
+	rec := recordedMessage{
+		Rule:    msg.RuleName,
+		Subject: msg.Subject,
+	}
+	for _, dest := range destinations {
+		p, err := notifications.RenderPayload(msg, dest)
+		if err != nil {
+			return fmt.Errorf("destination %s: %w", dest.ID, err)
+		}
+		rec.Payloads = append(rec.Payloads, p)
+	}
+	r.messages = append(r.messages, rec)
+	return nil
+}
+
+// fireRule renders and sends a new occurrence of rule and records it in
+// state. Errors are logged before being returned.
+func (h *handler) fireRule(ctx context.Context, sender messageSender, sched *scheduler.Scheduler, conf *config.Config, st *state.State, rule config.Rule, now time.Time) error {
+	// Get destinations for this rule
+	ruleDestinations := sender.GetDestinationsForRule(rule, conf.Destinations)
+
+	occurrence := st.Rules[rule.StateKey()].Occurrences + 1
+	msg, err := notifications.RenderMessage(rule, notifications.NewTemplateData(rule, occurrence, now))
+	if err != nil {
+		h.lgr.Error("render message error", "rule", rule.Name, "err", err)
+		return err
+	}
+	msg.Links = h.messageLinks(conf, rule.StateKey(), occurrence, now)
+
+	// Send notifications
+	err = h.deliver(ctx, sender, st, rule, occurrence, msg, ruleDestinations, now)
+	if err != nil {
+		h.lgr.Error("send notifications error", "rule", rule.Name, "err", err)
+		return err
+	}
+
+	err = sched.UpdateRuleState(conf, st, rule, now)
+	if err != nil {
+		h.lgr.Error("update rule state error", "rule", rule.Name, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+// sendRepeat re-sends an unacknowledged occurrence, adding the rule's
+// escalation destinations once its threshold is reached.
+func (h *handler) sendRepeat(ctx context.Context, sender messageSender, sched *scheduler.Scheduler, conf *config.Config, st *state.State, repeat scheduler.Repeat, now time.Time) error {
+	rule := repeat.Rule
+
+	if repeat.Escalate {
+		h.lgr.Info("escalating unacknowledged occurrence",
+			"rule", rule.Name, "occurrence", repeat.Occurrence, "repeat", repeat.Repeat)
+	}
+	target := rule
+	target.Destinations = repeat.Destinations()
+	ruleDestinations := sender.GetDestinationsForRule(target, conf.Destinations)
+
+	data := notifications.NewTemplateData(rule, repeat.Occurrence, now)
+	data.Repeat = repeat.Repeat
+	msg, err := notifications.RenderMessage(rule, data)
+	if err != nil {
+		h.lgr.Error("render message error", "rule", rule.Name, "err", err)
+		return err
+	}
+	msg.Links = h.messageLinks(conf, rule.StateKey(), repeat.Occurrence, now)
+
+	h.lgr.Info("repeating unacknowledged occurrence",
+		"rule", rule.Name, "occurrence", repeat.Occurrence, "repeat", repeat.Repeat)
+
+	err = h.deliver(ctx, sender, st, rule, repeat.Occurrence, msg, ruleDestinations, now)
+	if err != nil {
+		h.lgr.Error("send repeat error", "rule", rule.Name, "err", err)
+		return err
+	}
+
+	err = sched.RecordRepeat(st, rule, now)
+	if err != nil {
+		h.lgr.Error("record repeat error", "rule", rule.Name, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+// deliver sends msg to the rule's destinations. Destinations in quiet hours
+// have the message deferred to state or dropped, depending on their action.
+func (h *handler) deliver(ctx context.Context, sender messageSender, st *state.State, rule config.Rule, occurrence int, msg notifications.Message, destinations []config.Destination, now time.Time) error {
+	var sendNow []config.Destination
+
+	for _, dest := range destinations {
+		until, quiet := notifications.QuietUntil(dest, now)
+		if !quiet {
+			sendNow = append(sendNow, dest)
+			continue
+		}
+
+		if dest.QuietHours.Action == config.QuietHoursDrop {
+			h.lgr.Info("destination in quiet hours, dropping notification",
+				"rule", rule.Name, "destination", dest.ID)
+			continue
+		}
+
+		added := st.AddDeferred(state.DeferredDelivery{
+			RuleKey:       rule.StateKey(),
+			RuleName:      rule.Name,
+			Occurrence:    occurrence,
+			DestinationID: dest.ID,
+			Schedule:      msg.Schedule,
+			Subject:       msg.Subject,
+			Body:          msg.Body,
+			DeferredAt:    now,
+			SendAfter:     until,
+		})
+		if added {
+			h.lgr.Info("destination in quiet hours, deferring notification",
+				"rule", rule.Name, "destination", dest.ID, "send_after", until)
+		}
+	}
+
+	if len(sendNow) == 0 {
+		return nil
+	}
+
+	return sender.SendMessage(ctx, msg, sendNow)
+}
+
+// sendDeferred sends deferred deliveries whose quiet hours have ended. Sent
+// deliveries, and those whose destination no longer exists, are removed from
+// state; failed ones are kept for the next run.
+func (h *handler) sendDeferred(ctx context.Context, sender messageSender, conf *config.Config, st *state.State, now time.Time) []error {
+	destMap := make(map[string]config.Destination)
+	for _, dest := range conf.Destinations {
+		destMap[dest.ID] = dest
+	}
+
+	var (
+		errs      []error
+		remaining []state.DeferredDelivery
+	)
+
+	for _, d := range st.Deferred {
+		if now.Before(d.SendAfter) {
+			remaining = append(remaining, d)
+			continue
+		}
+
+		dest, ok := destMap[d.DestinationID]
+		if !ok {
+			h.lgr.Warn("dropping deferred notification for removed destination",
+				"rule", d.RuleName, "destination", d.DestinationID)
+			continue
+		}
+
+		// The quiet hours may have changed since the delivery was deferred
+		if until, quiet := notifications.QuietUntil(dest, now); quiet {
+			d.SendAfter = until
+			remaining = append(remaining, d)
+			continue
+		}
+
+		msg := notifications.Message{
+			RuleName: d.RuleName,
+			Schedule: d.Schedule,
+			Subject:  d.Subject,
+			Body:     d.Body,
+			Links:    h.messageLinks(conf, d.RuleKey, d.Occurrence, now),
+		}
+
+		h.lgr.Info("sending deferred notification",
+			"rule", d.RuleName, "destination", d.DestinationID, "deferred_at", d.DeferredAt)
+
+		err := sender.SendMessage(ctx, msg, []config.Destination{dest})
+		if err != nil {
+			h.lgr.Error("send deferred notification error", "rule", d.RuleName, "destination", d.DestinationID, "err", err)
+			errs = append(errs, fmt.Errorf("deferred %s: %w", d.RuleName, err))
+			remaining = append(remaining, d)
+			continue
+		}
+	}
+
+	st.Deferred = remaining
+	return errs
+}
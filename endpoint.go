@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/psanford/lambda-reminder/config"
+	"github.com/psanford/lambda-reminder/links"
+	"github.com/psanford/lambda-reminder/notifications"
+	"github.com/psanford/lambda-reminder/scheduler"
+	"github.com/psanford/lambda-reminder/state"
+)
+
+// Invoke is the Lambda entry point. HTTP requests from API Gateway or a
+// function URL are served by the link endpoint, payloads with an "action"
+// are admin requests and anything else is treated as a scheduled event.
+func (h *handler) Invoke(ctx context.Context, raw json.RawMessage) (any, error) {
+	var probe struct {
+		HTTPMethod     string          `json:"httpMethod"`
+		RequestContext json.RawMessage `json:"requestContext"`
+		Action         string          `json:"action"`
+	}
+	// A payload that isn't an object, or is malformed, falls through to the
+	// scheduled handler which ignores the event body anyway.
+	_ = json.Unmarshal(raw, &probe)
+
+	switch {
+	case probe.HTTPMethod != "":
+		// API Gateway REST API (payload format 1.0)
+		var req events.APIGatewayProxyRequest
+		err := json.Unmarshal(raw, &req)
+		if err != nil {
+			return nil, fmt.Errorf("decode api gateway request: %w", err)
+		}
+
+		status, body := h.handleLink(ctx, req.HTTPMethod, req.QueryStringParameters["token"])
+		return events.APIGatewayProxyResponse{
+			StatusCode: status,
+			Headers:    map[string]string{"Content-Type": "text/html; charset=utf-8"},
+			Body:       body,
+		}, nil
+	case len(probe.RequestContext) > 0:
+		// Function URL or API Gateway HTTP API (payload format 2.0)
+		var req events.LambdaFunctionURLRequest
+		err := json.Unmarshal(raw, &req)
+		if err != nil {
+			return nil, fmt.Errorf("decode function url request: %w", err)
+		}
+
+		query, _ := url.ParseQuery(req.RawQueryString)
+		status, body := h.handleLink(ctx, req.RequestContext.HTTP.Method, query.Get("token"))
+		return events.LambdaFunctionURLResponse{
+			StatusCode: status,
+			Headers:    map[string]string{"Content-Type": "text/html; charset=utf-8"},
+			Body:       body,
+		}, nil
+	case probe.Action != "":
+		var req AdminRequest
+		err := json.Unmarshal(raw, &req)
+		if err != nil {
+			return nil, fmt.Errorf("decode admin request: %w", err)
+		}
+
+		return h.handleAdmin(ctx, req)
+	default:
+		var evt events.CloudWatchEvent
+		_ = json.Unmarshal(raw, &evt)
+		return nil, h.Handler(ctx, evt)
+	}
+}
+
+// ServeHTTP serves the link endpoint in local mode.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status, body := h.handleLink(r.Context(), r.Method, r.URL.Query().Get("token"))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+// handleLink verifies a link token and returns an HTTP status and HTML page.
+// A GET only shows a confirmation form that POSTs back to the same URL, so
+// mail scanners and chat unfurlers that prefetch links don't acknowledge or
+// snooze anything on the recipient's behalf.
+func (h *handler) handleLink(ctx context.Context, method, token string) (int, string) {
+	if method != http.MethodGet && method != http.MethodPost {
+		return http.StatusMethodNotAllowed, linkPage("Method not allowed", "", false)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	conf, err := h.loadConfig(ctx)
+	if err != nil {
+		h.lgr.Error("link endpoint load error", "err", err)
+		return http.StatusInternalServerError, linkPage("Something went wrong", "", false)
+	}
+
+	if conf.Links == nil {
+		return http.StatusNotFound, linkPage("Links are not enabled", "", false)
+	}
+
+	now, err := h.configNow(conf)
+	if err != nil {
+		h.lgr.Error("link endpoint error", "err", err)
+		return http.StatusInternalServerError, linkPage("Something went wrong", "", false)
+	}
+
+	tok, err := links.Verify([]byte(conf.Links.SigningKey), token, now)
+	if errors.Is(err, links.ErrExpiredToken) {
+		return http.StatusGone, linkPage("This link has expired", "", false)
+	} else if err != nil {
+		h.lgr.Warn("invalid link token", "err", err)
+		return http.StatusForbidden, linkPage("This link is not valid", "", false)
+	}
+
+	rule, ok := ruleByStateKey(conf, tok.Rule)
+	if !ok {
+		return http.StatusNotFound, linkPage("This reminder no longer exists", "", false)
+	}
+
+	snooze, isSnooze := links.SnoozeDuration(tok.Action)
+	if tok.Action != links.ActionDone && !isSnooze {
+		return http.StatusBadRequest, linkPage("Unknown action", "", false)
+	}
+
+	if method == http.MethodGet {
+		title := fmt.Sprintf("%s: %s", links.Label(tok.Action), rule.Name)
+		return http.StatusOK, linkPage(title, fmt.Sprintf("Occurrence %d", tok.Occurrence), true)
+	}
+
+	var (
+		result    string
+		actionErr error
+	)
+	err = h.updateState(ctx, func(conf *config.Config, st *state.State) error {
+		sched := scheduler.New(h.lgr)
+		if isSnooze {
+			until := now.Add(snooze)
+			actionErr = sched.Snooze(conf, st, rule, tok.Occurrence, until)
+			result = fmt.Sprintf("Snoozed %s until %s", rule.Name, until.Format(time.RFC1123))
+		} else {
+			actionErr = sched.Acknowledge(st, rule, tok.Occurrence, now)
+			result = fmt.Sprintf("Acknowledged %s", rule.Name)
+		}
+		return actionErr
+	})
+	if actionErr != nil {
+		// Most likely the occurrence was already acknowledged or a newer
+		// one has been sent since.
+		return http.StatusConflict, linkPage("Nothing to do", actionErr.Error(), false)
+	} else if err != nil {
+		h.lgr.Error("link endpoint save state error", "err", err)
+		return http.StatusInternalServerError, linkPage("Something went wrong", "", false)
+	}
+
+	h.lgr.Info("link action applied", "rule", rule.Name, "occurrence", tok.Occurrence, "action", tok.Action)
+	return http.StatusOK, linkPage(result, "", false)
+}
+
+func linkPage(title, detail string, confirm bool) string {
+	page := fmt.Sprintf("<!DOCTYPE html>\n<html>\n<head><title>%s</title></head>\n<body>\n<h2>%s</h2>\n", html.EscapeString(title), html.EscapeString(title))
+	if detail != "" {
+		page += fmt.Sprintf("<p>%s</p>\n", html.EscapeString(detail))
+	}
+	if confirm {
+		page += "<form method=\"post\"><button type=\"submit\">Confirm</button></form>\n"
+	}
+	return page + "</body>\n</html>\n"
+}
+
+func ruleByStateKey(conf *config.Config, key string) (config.Rule, bool) {
+	for _, rule := range conf.Rules {
+		if rule.StateKey() == key {
+			return rule, true
+		}
+	}
+	return config.Rule{}, false
+}
+
+// dryRunConfig returns a copy of conf whose links are signed with a random
+// key, so links in dry run output look real but can't act on live state.
+func dryRunConfig(conf *config.Config) (*config.Config, error) {
+	if conf.Links == nil {
+		return conf, nil
+	}
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("generate link key: %w", err)
+	}
+
+	dryLinks := *conf.Links
+	dryLinks.SigningKey = hex.EncodeToString(key)
+	dry := *conf
+	dry.Links = &dryLinks
+	return &dry, nil
+}
+
+// messageLinks returns the signed action links for an occurrence, or nil
+// when links aren't configured.
+func (h *handler) messageLinks(conf *config.Config, ruleKey string, occurrence int, now time.Time) []notifications.Link {
+	if conf.Links == nil {
+		return nil
+	}
+
+	key := []byte(conf.Links.SigningKey)
+	expires := now.Add(conf.Links.LinkExpiry()).Unix()
+
+	var result []notifications.Link
+	for _, action := range links.Actions {
+		u, err := links.URL(conf.Links.BaseURL, key, links.Token{
+			Rule:       ruleKey,
+			Occurrence: occurrence,
+			Action:     action,
+			Expires:    expires,
+		})
+		if err != nil {
+			h.lgr.Error("build link error", "rule", ruleKey, "action", action, "err", err)
+			return nil
+		}
+		result = append(result, notifications.Link{Label: links.Label(action), URL: u})
+	}
+	return result
+}
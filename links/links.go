@@ -0,0 +1,131 @@
+// Package links signs and verifies the tokens in one-click acknowledge and
+// snooze links embedded in notifications.
+package links
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ActionDone     = "done"
+	ActionSnooze1h = "snooze_1h"
+	ActionSnooze1d = "snooze_1d"
+)
+
+// Actions lists the links added to each notification, in display order.
+var Actions = []string{ActionDone, ActionSnooze1h, ActionSnooze1d}
+
+// Label returns the human readable name of an action.
+func Label(action string) string {
+	switch action {
+	case ActionDone:
+		return "Done"
+	case ActionSnooze1h:
+		return "Snooze 1h"
+	case ActionSnooze1d:
+		return "Snooze 1d"
+	}
+	return action
+}
+
+// SnoozeDuration returns how long a snooze action snoozes for.
+func SnoozeDuration(action string) (time.Duration, bool) {
+	switch action {
+	case ActionSnooze1h:
+		return time.Hour, true
+	case ActionSnooze1d:
+		return 24 * time.Hour, true
+	}
+	return 0, false
+}
+
+// Token identifies an action on one occurrence of a rule.
+type Token struct {
+	// Rule is the rule's state key.
+	Rule       string `json:"r"`
+	Occurrence int    `json:"o"`
+	Action     string `json:"a"`
+	// Expires is a unix timestamp after which the token is rejected.
+	Expires int64 `json:"e"`
+}
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token expired")
+)
+
+var encoding = base64.RawURLEncoding
+
+// Sign returns tok encoded as "<payload>.<signature>", both base64url.
+func Sign(key []byte, tok Token) (string, error) {
+	payload, err := json.Marshal(tok)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := encoding.EncodeToString(payload)
+	return encoded + "." + encoding.EncodeToString(mac(key, encoded)), nil
+}
+
+// Verify checks a signed token's signature and expiry.
+func Verify(key []byte, signed string, now time.Time) (Token, error) {
+	encoded, sig, ok := strings.Cut(signed, ".")
+	if !ok {
+		return Token{}, ErrInvalidToken
+	}
+
+	gotMAC, err := encoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(gotMAC, mac(key, encoded)) {
+		return Token{}, ErrInvalidToken
+	}
+
+	payload, err := encoding.DecodeString(encoded)
+	if err != nil {
+		return Token{}, ErrInvalidToken
+	}
+
+	var tok Token
+	err = json.Unmarshal(payload, &tok)
+	if err != nil {
+		return Token{}, ErrInvalidToken
+	}
+
+	if now.Unix() > tok.Expires {
+		return Token{}, ErrExpiredToken
+	}
+
+	return tok, nil
+}
+
+func mac(key []byte, payload string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
+
+// URL returns baseURL with the signed token added as the "token" query
+// parameter.
+func URL(baseURL string, key []byte, tok Token) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("parse base url: %w", err)
+	}
+
+	signed, err := Sign(key, tok)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	q.Set("token", signed)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
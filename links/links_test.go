@@ -0,0 +1,96 @@
+package links
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
+
+	tok := Token{
+		Rule:       "renew_cert",
+		Occurrence: 3,
+		Action:     ActionSnooze1h,
+		Expires:    now.Add(time.Hour).Unix(),
+	}
+
+	signed, err := Sign(key, tok)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	got, err := Verify(key, signed, now)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if got != tok {
+		t.Errorf("Verify() = %+v, want %+v", got, tok)
+	}
+
+	_, err = Verify(key, signed, now.Add(2*time.Hour))
+	if !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Expected expired token error, got %v", err)
+	}
+
+	_, err = Verify([]byte("another key entirely, not ours!!"), signed, now)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected invalid token error for wrong key, got %v", err)
+	}
+
+	// Swap in a payload for a different action with the original signature
+	forged := tok
+	forged.Action = ActionDone
+	forgedSigned, err := Sign(key, forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedPayload, _, _ := strings.Cut(forgedSigned, ".")
+	_, sig, _ := strings.Cut(signed, ".")
+	tampered := forgedPayload + "." + sig
+	_, err = Verify(key, tampered, now)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected invalid token error for tampered payload, got %v", err)
+	}
+
+	for _, bad := range []string{"", "nodot", "!!!.!!!"} {
+		if _, err := Verify(key, bad, now); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Verify(%q) error = %v, want invalid token", bad, err)
+		}
+	}
+}
+
+func TestURL(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	tok := Token{
+		Rule:    "renew_cert",
+		Action:  ActionDone,
+		Expires: time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC).Unix(),
+	}
+
+	link, err := URL("https://example.lambda-url.us-east-1.on.aws/ack?src=email", key, tok)
+	if err != nil {
+		t.Fatalf("URL() error = %v", err)
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Path != "/ack" || u.Query().Get("src") != "email" {
+		t.Errorf("Expected base url to be kept, got %s", link)
+	}
+
+	got, err := Verify(key, u.Query().Get("token"), time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if got != tok {
+		t.Errorf("Verify() = %+v, want %+v", got, tok)
+	}
+}
@@ -0,0 +1,117 @@
+package notifications
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/psanford/lambda-reminder/config"
+)
+
+// Message is a rendered reminder ready to be sent to destinations.
+type Message struct {
+	RuleName string
+	Schedule string
+	Subject  string
+	Body     string
+	// Links are optional actions, such as acknowledge or snooze, rendered
+	// after the body.
+	Links []Link
+}
+
+// Link is a labelled URL attached to a message.
+type Link struct {
+	Label string
+	URL   string
+}
+
+// textWithLinks returns the body followed by one "Label: URL" line per link.
+func (m Message) textWithLinks() string {
+	if len(m.Links) == 0 {
+		return m.Body
+	}
+
+	var b strings.Builder
+	b.WriteString(m.Body)
+	b.WriteString("\n")
+	for _, l := range m.Links {
+		fmt.Fprintf(&b, "\n%s: %s", l.Label, l.URL)
+	}
+	return b.String()
+}
+
+// TemplateData is the data available to rule subject and body templates,
+// e.g. "Reminder {{.Occurrence}} of {{.MaxOccurrences}}".
+type TemplateData struct {
+	Rule string
+	Time time.Time
+	// Occurrence is the 1-based number of this firing of the rule.
+	Occurrence int
+	// MaxOccurrences is the rule's limit, zero if it has none.
+	MaxOccurrences int
+	// Remaining is the number of occurrences left after this one. It is
+	// only meaningful when MaxOccurrences is set.
+	Remaining int
+	// Repeat is the number of times this occurrence has been re-sent
+	// because it wasn't acknowledged, zero for the first send.
+	Repeat int
+}
+
+// NewTemplateData returns template data for the given occurrence of rule.
+func NewTemplateData(rule config.Rule, occurrence int, t time.Time) TemplateData {
+	data := TemplateData{
+		Rule:           rule.Name,
+		Time:           t,
+		Occurrence:     occurrence,
+		MaxOccurrences: rule.MaxOccurrences,
+	}
+	if rule.MaxOccurrences > 0 {
+		data.Remaining = max(rule.MaxOccurrences-occurrence, 0)
+	}
+	return data
+}
+
+// MessageForRule returns the rule's subject and body without rendering
+// templates.
+func MessageForRule(rule config.Rule) Message {
+	return Message{
+		RuleName: rule.Name,
+		Schedule: rule.ScheduleExpr(),
+		Subject:  rule.Subject,
+		Body:     rule.Body,
+	}
+}
+
+// RenderMessage executes the rule's subject and body templates.
+func RenderMessage(rule config.Rule, data TemplateData) (Message, error) {
+	msg := MessageForRule(rule)
+
+	subject, err := renderTemplate("subject", rule.Subject, data)
+	if err != nil {
+		return Message{}, err
+	}
+	body, err := renderTemplate("body", rule.Body, data)
+	if err != nil {
+		return Message{}, err
+	}
+
+	msg.Subject = subject
+	msg.Body = body
+	return msg, nil
+}
+
+func renderTemplate(name, text string, data TemplateData) (string, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parse %s template: %w", name, err)
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", fmt.Errorf("render %s template: %w", name, err)
+	}
+	return buf.String(), nil
+}
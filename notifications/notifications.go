@@ -5,8 +5,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"html"
 	"log/slog"
 	"net/http"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/service/sesv2"
 	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
@@ -28,29 +30,35 @@ func NewSender(snsClient *sns.Client, sesClient *sesv2.Client, lgr *slog.Logger)
 	}
 }
 
+// SendNotifications sends the rule's subject and body as-is. Use SendMessage
+// to send a message rendered with RenderMessage.
 func (n *NotificationSender) SendNotifications(ctx context.Context, rule config.Rule, destinations []config.Destination) error {
+	return n.SendMessage(ctx, MessageForRule(rule), destinations)
+}
+
+func (n *NotificationSender) SendMessage(ctx context.Context, msg Message, destinations []config.Destination) error {
 	var errors []error
 
 	for _, dest := range destinations {
-		n.lgr.Info("sending notification", "rule", rule.Name, "destination", dest.ID, "type", dest.Type)
+		n.lgr.Info("sending notification", "rule", msg.RuleName, "destination", dest.ID, "type", dest.Type)
 
 		var err error
 		switch dest.Type {
 		case "sns":
-			err = n.sendSNS(ctx, rule, dest)
+			err = n.sendSNS(ctx, msg, dest)
 		case "ses":
-			err = n.sendSES(ctx, rule, dest)
+			err = n.sendSES(ctx, msg, dest)
 		case "slack_webhook":
-			err = n.sendSlackWebhook(ctx, rule, dest)
+			err = n.sendSlackWebhook(ctx, msg, dest)
 		case "log":
-			n.lgr.Info("log notification event", "subject", rule.Subject, "body", rule.Body)
+			n.lgr.Info("log notification event", "subject", msg.Subject, "body", msg.Body, "links", msg.Links)
 		default:
 			err = fmt.Errorf("unsupported destination type: %s", dest.Type)
 		}
 
 		if err != nil {
 			n.lgr.Error("failed to send notification",
-				"rule", rule.Name,
+				"rule", msg.RuleName,
 				"destination", dest.ID,
 				"type", dest.Type,
 				"err", err)
@@ -65,13 +73,13 @@ func (n *NotificationSender) SendNotifications(ctx context.Context, rule config.
 	return nil
 }
 
-func (n *NotificationSender) sendSNS(ctx context.Context, rule config.Rule, dest config.Destination) error {
-	message := fmt.Sprintf("Reminder: %s\n\n%s", rule.Subject, rule.Body)
+func (n *NotificationSender) sendSNS(ctx context.Context, msg Message, dest config.Destination) error {
+	message := snsMessage(msg)
 
 	_, err := n.snsClient.Publish(ctx, &sns.PublishInput{
 		TopicArn: &dest.SNSARN,
 		Message:  &message,
-		Subject:  &rule.Subject,
+		Subject:  &msg.Subject,
 	})
 	if err != nil {
 		return fmt.Errorf("publish to SNS: %w", err)
@@ -80,15 +88,24 @@ func (n *NotificationSender) sendSNS(ctx context.Context, rule config.Rule, dest
 	return nil
 }
 
-func (n *NotificationSender) sendSES(ctx context.Context, rule config.Rule, dest config.Destination) error {
-	emailBody := fmt.Sprintf(`
+func snsMessage(msg Message) string {
+	return fmt.Sprintf("Reminder: %s\n\n%s", msg.Subject, msg.textWithLinks())
+}
+
+func sesHTMLBody(msg Message) string {
+	return fmt.Sprintf(`
 <html>
 <head><title>%s</title></head>
 <body>
 <h2>%s</h2>
-<p>%s</p>
+<p>%s</p>%s
 </body>
-</html>`, rule.Subject, rule.Subject, rule.Body)
+</html>`, msg.Subject, msg.Subject, msg.Body, htmlLinks(msg.Links))
+}
+
+func (n *NotificationSender) sendSES(ctx context.Context, msg Message, dest config.Destination) error {
+	emailBody := sesHTMLBody(msg)
+	textBody := msg.textWithLinks()
 
 	_, err := n.sesClient.SendEmail(ctx, &sesv2.SendEmailInput{
 		FromEmailAddress: &dest.FromEmail,
@@ -98,14 +115,14 @@ func (n *NotificationSender) sendSES(ctx context.Context, rule config.Rule, dest
 		Content: &types.EmailContent{
 			Simple: &types.Message{
 				Subject: &types.Content{
-					Data: &rule.Subject,
+					Data: &msg.Subject,
 				},
 				Body: &types.Body{
 					Html: &types.Content{
 						Data: &emailBody,
 					},
 					Text: &types.Content{
-						Data: &rule.Body,
+						Data: &textBody,
 					},
 				},
 			},
@@ -126,10 +143,18 @@ type SlackMessage struct {
 }
 
 type SlackAttachment struct {
-	Color  string       `json:"color,omitempty"`
-	Title  string       `json:"title,omitempty"`
-	Text   string       `json:"text,omitempty"`
-	Fields []SlackField `json:"fields,omitempty"`
+	Color   string        `json:"color,omitempty"`
+	Title   string        `json:"title,omitempty"`
+	Text    string        `json:"text,omitempty"`
+	Fields  []SlackField  `json:"fields,omitempty"`
+	Actions []SlackAction `json:"actions,omitempty"`
+}
+
+// SlackAction is a link button on an attachment.
+type SlackAction struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+	URL  string `json:"url"`
 }
 
 type SlackField struct {
@@ -138,31 +163,36 @@ type SlackField struct {
 	Short bool   `json:"short"`
 }
 
-func (n *NotificationSender) sendSlackWebhook(ctx context.Context, rule config.Rule, dest config.Destination) error {
-	slackMsg := SlackMessage{
-		Text:      fmt.Sprintf("Reminder: %s", rule.Subject),
+func slackMessage(msg Message) SlackMessage {
+	return SlackMessage{
+		Text:      fmt.Sprintf("Reminder: %s", msg.Subject),
 		Username:  "Lambda Reminder",
 		IconEmoji: ":bell:",
 		Attachments: []SlackAttachment{
 			{
 				Color: "good",
-				Title: rule.Subject,
-				Text:  rule.Body,
+				Title: msg.Subject,
+				Text:  msg.Body,
 				Fields: []SlackField{
 					{
 						Title: "Rule",
-						Value: rule.Name,
+						Value: msg.RuleName,
 						Short: true,
 					},
 					{
 						Title: "Schedule",
-						Value: rule.Cron,
+						Value: msg.Schedule,
 						Short: true,
 					},
 				},
+				Actions: slackActions(msg.Links),
 			},
 		},
 	}
+}
+
+func (n *NotificationSender) sendSlackWebhook(ctx context.Context, msg Message, dest config.Destination) error {
+	slackMsg := slackMessage(msg)
 
 	msgBytes, err := json.Marshal(slackMsg)
 	if err != nil {
@@ -189,6 +219,30 @@ func (n *NotificationSender) sendSlackWebhook(ctx context.Context, rule config.R
 	return nil
 }
 
+func htmlLinks(links []Link) string {
+	if len(links) == 0 {
+		return ""
+	}
+
+	parts := make([]string, 0, len(links))
+	for _, l := range links {
+		parts = append(parts, fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(l.URL), html.EscapeString(l.Label)))
+	}
+	return "\n<p>" + strings.Join(parts, " | ") + "</p>"
+}
+
+func slackActions(links []Link) []SlackAction {
+	var actions []SlackAction
+	for _, l := range links {
+		actions = append(actions, SlackAction{
+			Type: "button",
+			Text: l.Label,
+			URL:  l.URL,
+		})
+	}
+	return actions
+}
+
 func (n *NotificationSender) GetDestinationsForRule(rule config.Rule, allDestinations []config.Destination) []config.Destination {
 	var ruleDestinations []config.Destination
 
@@ -4,7 +4,9 @@ import (
 	"context"
 	"log/slog"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/psanford/lambda-reminder/config"
 )
@@ -175,3 +177,68 @@ func TestSlackMessageStructure(t *testing.T) {
 		t.Errorf("Expected Schedule field with value '%s', got '%s': '%s'", rule.Cron, scheduleField.Title, scheduleField.Value)
 	}
 }
+
+func TestRenderMessage(t *testing.T) {
+	rule := config.Rule{
+		Name:           "weekly_checkin",
+		Cron:           "0 9 * * 1",
+		Subject:        "Check-in {{.Occurrence}} of {{.MaxOccurrences}}",
+		Body:           "{{.Remaining}} left for {{.Rule}}",
+		MaxOccurrences: 6,
+	}
+
+	msg, err := RenderMessage(rule, NewTemplateData(rule, 2, time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)))
+	if err != nil {
+		t.Fatalf("RenderMessage() error = %v", err)
+	}
+
+	if msg.Subject != "Check-in 2 of 6" {
+		t.Errorf("Expected subject 'Check-in 2 of 6', got '%s'", msg.Subject)
+	}
+
+	if msg.Body != "4 left for weekly_checkin" {
+		t.Errorf("Expected body '4 left for weekly_checkin', got '%s'", msg.Body)
+	}
+
+	if msg.RuleName != rule.Name || msg.Schedule != rule.Cron {
+		t.Errorf("Expected rule name and schedule to be copied, got %q %q", msg.RuleName, msg.Schedule)
+	}
+}
+
+func TestRenderPayload(t *testing.T) {
+	msg := Message{
+		RuleName: "renew_cert",
+		Schedule: "0 9 1 * *",
+		Subject:  "Renew cert",
+		Body:     "The cert expires soon",
+		Links:    []Link{{Label: "Done", URL: "https://example.com/ack?token=abc"}},
+	}
+
+	p, err := RenderPayload(msg, config.Destination{ID: "pager", Type: "sns", SNSARN: "arn:aws:sns:us-east-1:123456789:pager"})
+	if err != nil {
+		t.Fatalf("RenderPayload() error = %v", err)
+	}
+	want := "Reminder: Renew cert\n\nThe cert expires soon\n\nDone: https://example.com/ack?token=abc"
+	if p.Body != want {
+		t.Errorf("SNS body = %q, want %q", p.Body, want)
+	}
+
+	p, err = RenderPayload(msg, config.Destination{ID: "slack", Type: "slack_webhook", WebhookURL: "https://hooks.slack.com/services/T000/B000/secret"})
+	if err != nil {
+		t.Fatalf("RenderPayload() error = %v", err)
+	}
+	if p.Target != "hooks.slack.com" {
+		t.Errorf("Expected webhook path to be omitted from target, got %q", p.Target)
+	}
+	if strings.Contains(p.Body, "secret") {
+		t.Error("Expected webhook url not to appear in payload")
+	}
+	if !strings.Contains(p.Body, `"url": "https://example.com/ack?token=abc"`) {
+		t.Errorf("Expected slack payload to include link button, got %s", p.Body)
+	}
+
+	_, err = RenderPayload(msg, config.Destination{ID: "fax", Type: "fax"})
+	if err == nil {
+		t.Error("Expected error for unsupported destination type")
+	}
+}
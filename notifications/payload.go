@@ -0,0 +1,60 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/psanford/lambda-reminder/config"
+)
+
+// Payload is what would be sent to a destination, rendered exactly as the
+// sender would send it. It is used for dry runs.
+type Payload struct {
+	Destination string `json:"destination"`
+	Type        string `json:"type"`
+	// Target is the SNS topic, email recipients or webhook host. Webhook
+	// paths are omitted since they act as credentials.
+	Target  string `json:"target,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Body    string `json:"body"`
+	// HTML is the HTML part of an email.
+	HTML string `json:"html,omitempty"`
+}
+
+// RenderPayload renders msg for dest without sending it.
+func RenderPayload(msg Message, dest config.Destination) (Payload, error) {
+	p := Payload{
+		Destination: dest.ID,
+		Type:        dest.Type,
+	}
+
+	switch dest.Type {
+	case "sns":
+		p.Target = dest.SNSARN
+		p.Subject = msg.Subject
+		p.Body = snsMessage(msg)
+	case "ses":
+		p.Target = strings.Join(dest.ToEmails, ", ")
+		p.Subject = msg.Subject
+		p.Body = msg.textWithLinks()
+		p.HTML = sesHTMLBody(msg)
+	case "slack_webhook":
+		if u, err := url.Parse(dest.WebhookURL); err == nil {
+			p.Target = u.Host
+		}
+		body, err := json.MarshalIndent(slackMessage(msg), "", "  ")
+		if err != nil {
+			return Payload{}, fmt.Errorf("marshal slack message: %w", err)
+		}
+		p.Body = string(body)
+	case "log":
+		p.Subject = msg.Subject
+		p.Body = msg.textWithLinks()
+	default:
+		return Payload{}, fmt.Errorf("unsupported destination type: %s", dest.Type)
+	}
+
+	return p, nil
+}
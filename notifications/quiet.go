@@ -0,0 +1,31 @@
+package notifications
+
+import (
+	"time"
+
+	"github.com/psanford/lambda-reminder/config"
+)
+
+// QuietUntil reports whether t is within dest's quiet hours and, if so, when
+// they end. Quiet hours without a timezone use t's location.
+func QuietUntil(dest config.Destination, t time.Time) (time.Time, bool) {
+	q := dest.QuietHours
+	if q == nil {
+		return time.Time{}, false
+	}
+
+	local := t.In(q.Location(t.Location()))
+
+	// A window that crosses midnight may have started the day before
+	for _, offset := range []int{-1, 0} {
+		start, end, ok := q.Window(local.AddDate(0, 0, offset))
+		if !ok {
+			continue
+		}
+		if !local.Before(start) && local.Before(end) {
+			return end, true
+		}
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,115 @@
+package notifications
+
+import (
+	"testing"
+	"time"
+
+	"github.com/psanford/lambda-reminder/config"
+)
+
+func TestQuietUntil(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	overnight := &config.QuietHours{
+		Start: "22:00",
+		End:   "07:00",
+	}
+	weekend := &config.QuietHours{
+		Start: "00:00",
+		End:   "00:00",
+		Days:  []string{"sat", "sun"},
+	}
+	zoned := &config.QuietHours{
+		Start:    "22:00",
+		End:      "07:00",
+		Timezone: "America/New_York",
+	}
+
+	tests := []struct {
+		name      string
+		quiet     *config.QuietHours
+		now       time.Time
+		wantQuiet bool
+		wantUntil time.Time
+	}{
+		{
+			name:      "no quiet hours",
+			now:       time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC),
+			wantQuiet: false,
+		},
+		{
+			name:      "before window",
+			quiet:     overnight,
+			now:       time.Date(2024, 1, 15, 21, 59, 0, 0, time.UTC),
+			wantQuiet: false,
+		},
+		{
+			name:      "evening in window",
+			quiet:     overnight,
+			now:       time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC),
+			wantQuiet: true,
+			wantUntil: time.Date(2024, 1, 16, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "early morning in window that started the day before",
+			quiet:     overnight,
+			now:       time.Date(2024, 1, 16, 3, 0, 0, 0, time.UTC),
+			wantQuiet: true,
+			wantUntil: time.Date(2024, 1, 16, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "window end is not quiet",
+			quiet:     overnight,
+			now:       time.Date(2024, 1, 16, 7, 0, 0, 0, time.UTC),
+			wantQuiet: false,
+		},
+		{
+			name:      "saturday is quiet all day",
+			quiet:     weekend,
+			now:       time.Date(2024, 1, 20, 12, 0, 0, 0, time.UTC),
+			wantQuiet: true,
+			wantUntil: time.Date(2024, 1, 21, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "monday is not quiet",
+			quiet:     weekend,
+			now:       time.Date(2024, 1, 22, 12, 0, 0, 0, time.UTC),
+			wantQuiet: false,
+		},
+		{
+			name:      "window in its own timezone",
+			quiet:     zoned,
+			now:       time.Date(2024, 1, 16, 4, 0, 0, 0, time.UTC), // 23:00 in New York
+			wantQuiet: true,
+			wantUntil: time.Date(2024, 1, 16, 7, 0, 0, 0, newYork),
+		},
+		{
+			name:      "timezone makes utc night not quiet",
+			quiet:     zoned,
+			now:       time.Date(2024, 1, 16, 1, 0, 0, 0, time.UTC), // 20:00 in New York
+			wantQuiet: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := config.Destination{
+				ID:         "pager",
+				Type:       "sns",
+				QuietHours: tt.quiet,
+			}
+
+			until, quiet := QuietUntil(dest, tt.now)
+			if quiet != tt.wantQuiet {
+				t.Fatalf("QuietUntil() quiet = %v, want %v", quiet, tt.wantQuiet)
+			}
+
+			if quiet && !until.Equal(tt.wantUntil) {
+				t.Errorf("QuietUntil() until = %v, want %v", until, tt.wantUntil)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// Package redact keeps secret values, such as resolved config references,
+// out of logs.
+package redact
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Replacement is what secrets are replaced with.
+const Replacement = "[REDACTED]"
+
+// minLen is the shortest value that is redacted. Shorter values are too
+// likely to match unrelated text, and aren't much of a secret.
+const minLen = 6
+
+// Set is a set of secret values. The zero value and a nil Set are empty.
+type Set struct {
+	mu     sync.RWMutex
+	values []string
+}
+
+// Add adds secrets to the set.
+func (s *Set) Add(secrets ...string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range secrets {
+		if len(v) < minLen || contains(s.values, v) {
+			continue
+		}
+		s.values = append(s.values, v)
+	}
+	// Longest first so a secret containing another is replaced whole
+	sort.Slice(s.values, func(i, j int) bool {
+		return len(s.values[i]) > len(s.values[j])
+	})
+}
+
+func contains(values []string, v string) bool {
+	for _, existing := range values {
+		if existing == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact replaces every secret in str.
+func (s *Set) Redact(str string) string {
+	if s == nil {
+		return str
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, v := range s.values {
+		str = strings.ReplaceAll(str, v, Replacement)
+	}
+	return str
+}
+
+// Handler is a slog.Handler that redacts secrets from messages and
+// attribute values before passing records on.
+type Handler struct {
+	next    slog.Handler
+	secrets *Set
+}
+
+// NewHandler returns a Handler that redacts secrets from records logged to
+// next. Secrets added to the set later are redacted too.
+func NewHandler(next slog.Handler, secrets *Set) *Handler {
+	return &Handler{
+		next:    next,
+		secrets: secrets,
+	}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, h.secrets.Redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.redactAttr(a)
+	}
+	return &Handler{
+		next:    h.next.WithAttrs(redacted),
+		secrets: h.secrets,
+	}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{
+		next:    h.next.WithGroup(name),
+		secrets: h.secrets,
+	}
+}
+
+func (h *Handler) redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, h.secrets.Redact(v.String()))
+	case slog.KindGroup:
+		attrs := v.Group()
+		redacted := make([]any, len(attrs))
+		for i, ga := range attrs {
+			redacted[i] = h.redactAttr(ga)
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindAny:
+		// Errors and other values are only replaced by their text if they
+		// contain a secret
+		s := fmt.Sprint(v.Any())
+		if r := h.secrets.Redact(s); r != s {
+			return slog.String(a.Key, r)
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
@@ -0,0 +1,45 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	secrets := &Set{}
+	lgr := slog.New(NewHandler(slog.NewTextHandler(&buf, nil), secrets))
+
+	secret := "https://hooks.slack.com/services/T000/B000/XXXX"
+	secrets.Add(secret, "short")
+
+	lgr.With("url", secret).WithGroup("send").Info("posting to "+secret,
+		"err", errors.New("Post \""+secret+"\": connection refused"),
+		slog.Group("dest", "webhook", secret),
+		"note", "short")
+
+	out := buf.String()
+	if strings.Contains(out, "services/T000") {
+		t.Errorf("Expected the secret to be redacted, got %s", out)
+	}
+	if n := strings.Count(out, Replacement); n != 4 {
+		t.Errorf("Expected 4 redactions, got %d in %s", n, out)
+	}
+	if !strings.Contains(out, "connection refused") {
+		t.Errorf("Expected the rest of the error to be kept, got %s", out)
+	}
+	if !strings.Contains(out, "note=short") {
+		t.Errorf("Expected values shorter than %d to be kept, got %s", minLen, out)
+	}
+}
+
+func TestNilSet(t *testing.T) {
+	var s *Set
+	s.Add("secret value")
+	if got := s.Redact("a secret value"); got != "a secret value" {
+		t.Errorf("Expected a nil set to redact nothing, got %q", got)
+	}
+}
@@ -2,52 +2,128 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 	_ "time/tzdata"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/aws-sdk-go-v2/service/sesv2"
 	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/psanford/lambda-reminder/clock"
 	"github.com/psanford/lambda-reminder/config"
 	"github.com/psanford/lambda-reminder/notifications"
+	"github.com/psanford/lambda-reminder/redact"
 	"github.com/psanford/lambda-reminder/scheduler"
+	"github.com/psanford/lambda-reminder/simulator"
 	"github.com/psanford/lambda-reminder/state"
 )
 
-var mode = flag.String("mode", "lambda", "Run mode (lambda|local)")
-var configPath = flag.String("config", "", "Local config path, blank means load from s3")
+var mode = flag.String("mode", "lambda", "Run mode (lambda|local|fire|ack|skip|snooze|simulate|convert|schema)")
+var configPath = flag.String("config", "", "Local config file or directory, blank means load from s3")
+var configFormat = flag.String("config_format", os.Getenv("CONFIG_FORMAT"), "Config file format (toml|yaml|json), blank means detect from the file extension")
 var statePath = flag.String("state_path", "", "Local state path, blank means load from s3")
+var ruleName = flag.String("rule", "", "Rule name or id for the fire, ack, skip and snooze modes")
+var dryRun = flag.Bool("dry_run", false, "Run the scheduled pipeline once, printing due rules, rendered payloads and state changes without sending or saving state")
+var nowOverride = flag.String("now", "", "Current time override for -dry_run, RFC 3339")
+var simFrom = flag.String("from", "", "Simulation start time, RFC 3339")
+var simTo = flag.String("to", "", "Simulation end time, RFC 3339")
+var simTick = flag.Duration("tick", simulator.DefaultTick, "Simulated invocation interval")
+var simGaps windowList
+var simFailures failureList
+var listenAddr = flag.String("listen", "", "Address to serve the acknowledge/snooze link endpoint on in local mode, e.g. :8080")
+var occurrence = flag.Int("occurrence", 0, "Occurrence to acknowledge or snooze, 0 means the pending one")
+var skipCount = flag.Int("count", 1, "Number of occurrences to skip in skip mode, 0 cancels a skip")
+var snoozeUntil = flag.String("until", "", "Time to snooze until in snooze mode, RFC 3339 or a duration from now like 2h or 3d")
+var secretsFile = flag.String("secrets_file", "", "JSON file of config reference values to use instead of SSM and Secrets Manager, for testing offline")
+var convertOut = flag.String("out", "", "Output file for the convert and schema modes, blank means stdout")
+var convertFormat = flag.String("out_format", "", "Output format for convert mode (toml|yaml|json), blank means detect from -out")
+
+func init() {
+	flag.Var(&simGaps, "gap", "Simulate no invocations between from/to (RFC 3339), repeatable")
+	flag.Var(&simFailures, "fail", "Simulate sends to a destination failing, destination@from/to (RFC 3339), repeatable")
+}
 
 func main() {
 	flag.Parse()
 
-	lgr := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	secrets := &redact.Set{}
+	lgr := slog.New(redact.NewHandler(slog.NewJSONHandler(os.Stderr, nil), secrets))
 	slog.SetDefault(lgr)
 
+	format, err := config.ParseFormat(*configFormat)
+	if err != nil {
+		lgr.Error("invalid -config_format", "err", err)
+		os.Exit(1)
+	}
+
 	ctx := context.Background()
 	cfg, err := awsconfig.LoadDefaultConfig(ctx)
 	if err != nil {
 		panic(fmt.Sprintf("load aws config: %s", err))
 	}
 
-	h := &handler{
-		s3Client:  s3.NewFromConfig(cfg),
-		snsClient: sns.NewFromConfig(cfg),
-		sesClient: sesv2.NewFromConfig(cfg),
-		lgr:       lgr,
+	loader := &config.Loader{
+		Format:    format,
+		Resolvers: config.DefaultResolvers(),
+	}
+	loader.Resolvers["ssm"] = config.SSMResolver{Client: ssm.NewFromConfig(cfg)}
+	loader.Resolvers["secretsmanager"] = config.SecretsManagerResolver{Client: secretsmanager.NewFromConfig(cfg)}
+	if *secretsFile != "" {
+		fr, err := config.LoadFileResolver(*secretsFile)
+		if err != nil {
+			lgr.Error("load secrets file", "err", err)
+			os.Exit(1)
+		}
+		loader.Resolvers["ssm"] = fr
+		loader.Resolvers["secretsmanager"] = fr
+	}
+
+	h := newHandler(cfg, lgr)
+	h.secrets = secrets
+	h.loader = loader
+
+	switch {
+	case *dryRun:
+		err = h.dryRunCommand(ctx, *nowOverride)
+	case *mode == "local":
+		sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		err = h.localRunLoop(sigCtx)
+		stop()
+	case *mode == "fire":
+		err = h.fireCommand(ctx, *ruleName)
+	case *mode == "ack":
+		err = h.ackCommand(ctx, *ruleName, *occurrence)
+	case *mode == "simulate":
+		err = h.simulateCommand(ctx, os.Stdout, *simFrom, *simTo, *simTick, simGaps, simFailures)
+	case *mode == "skip":
+		err = h.skipCommand(ctx, *ruleName, *skipCount)
+	case *mode == "snooze":
+		err = h.snoozeCommand(ctx, *ruleName, *occurrence, *snoozeUntil)
+	case *mode == "convert":
+		err = h.convertCommand(ctx, os.Stdout, *convertOut, *convertFormat)
+	case *mode == "schema":
+		err = schemaCommand(os.Stdout, *convertOut)
+	default:
+		lambda.Start(h.Invoke)
 	}
 
-	if *mode == "local" {
-		h.localRunLoop(ctx)
-	} else {
-		lambda.Start(h.Handler)
+	if err != nil {
+		lgr.Error(*mode+" error", "err", err)
+		os.Exit(1)
 	}
 }
 
@@ -56,85 +132,274 @@ type handler struct {
 	snsClient *sns.Client
 	sesClient *sesv2.Client
 	lgr       *slog.Logger
+
+	// secrets collects resolved config secrets, which lgr redacts.
+	secrets *redact.Set
+	// loader loads the config. Nil means config.NewLoader.
+	loader *config.Loader
+
+	// sender sends notifications. Tests replace it to capture messages.
+	sender messageSender
+	// clock is the source of the current time. Tests use a clock.Fake.
+	clock clock.Clock
+
+	// mu serializes state updates between scheduled runs and the link
+	// endpoint in local mode.
+	mu sync.Mutex
+
+	// confMu guards the config cache. conf is the last good config,
+	// confTag the ETag or file fingerprint of the last version read and
+	// confErr its error if that version was bad. confReadAt is when the
+	// config was last read in full, which bounds how stale its resolved
+	// secrets can be.
+	confMu         sync.Mutex
+	watchingConfig bool
+	conf           *config.Config
+	confTag        string
+	confErr        error
+	confReadAt     time.Time
+	// configReloaded wakes the local run loop after a config reload.
+	configReloaded chan struct{}
+}
+
+func newHandler(cfg aws.Config, lgr *slog.Logger) *handler {
+	snsClient := sns.NewFromConfig(cfg)
+	sesClient := sesv2.NewFromConfig(cfg)
+
+	return &handler{
+		s3Client:  s3.NewFromConfig(cfg),
+		snsClient: snsClient,
+		sesClient: sesClient,
+		lgr:       lgr,
+		sender:    notifications.NewSender(snsClient, sesClient, lgr),
+		clock:     clock.Real{},
+	}
 }
 
 func (h *handler) Handler(ctx context.Context, evt events.CloudWatchEvent) error {
 	h.lgr.Info("processing scheduled event")
 
-	conf, err := config.LoadConfig(ctx, h.s3Client, h.lgr, *configPath)
+	_, err := h.run(ctx, runOptions{})
+	return err
+}
+
+type runOptions struct {
+	// DryRun records the messages that would be sent instead of sending
+	// them, and doesn't save state. Links are signed with a throwaway key.
+	DryRun bool
+	// Now overrides the current time when set.
+	Now time.Time
+}
+
+// runResult describes what a run did, or would have done for a dry run.
+type runResult struct {
+	Now      time.Time         `json:"now"`
+	DueRules []string          `json:"due_rules"`
+	Messages []recordedMessage `json:"messages,omitempty"`
+	Changes  []state.Change    `json:"state_changes,omitempty"`
+	// NextWake is when something next becomes due, zero if nothing is
+	// scheduled.
+	NextWake time.Time `json:"next_wake,omitzero"`
+}
+
+// run sends deferred deliveries, due rules and due repeats and saves the
+// updated state.
+func (h *handler) run(ctx context.Context, opts runOptions) (*runResult, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	conf, st, err := h.load(ctx)
 	if err != nil {
-		return fmt.Errorf("load config: %w", err)
+		return nil, err
 	}
 
-	st, err := state.LoadState(ctx, h.s3Client, h.lgr, *statePath)
-	if err != nil {
-		return fmt.Errorf("load state: %w", err)
+	if opts.DryRun {
+		conf, err = dryRunConfig(conf)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	sched := scheduler.New(h.lgr)
-	now := time.Now()
+	now, err := h.configNow(conf)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Now.IsZero() {
+		now = opts.Now.In(now.Location())
+	}
 
-	var location *time.Location
-	if conf.Timezone != "" {
-		location, err = time.LoadLocation(conf.Timezone)
-		if err != nil {
-			return fmt.Errorf("invalid timezone %s: %w", conf.Timezone, err)
-		}
-		now = now.In(location)
+	before, err := st.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("copy state: %w", err)
 	}
 
+	sched.ReconcileState(conf, st, now)
+
 	dueRules, err := sched.GetDueRules(conf, st, now)
 	if err != nil {
-		return fmt.Errorf("get due rules: %w", err)
+		return nil, fmt.Errorf("get due rules: %w", err)
 	}
 
-	notificationSender := notifications.NewSender(h.snsClient, h.sesClient, h.lgr)
+	result := &runResult{Now: now}
+	for _, rule := range dueRules {
+		result.DueRules = append(result.DueRules, rule.Name)
+	}
+
+	sender := h.sender
+	recorder := &recordingSender{NotificationSender: notifications.NewSender(nil, nil, h.lgr)}
+	if opts.DryRun {
+		sender = recorder
+	}
 
-	var errs []error
+	errs := h.sendDeferred(ctx, sender, conf, st, now)
 
 	for _, rule := range dueRules {
-		// Get destinations for this rule
-		ruleDestinations := notificationSender.GetDestinationsForRule(rule, conf.Destinations)
-
-		// Send notifications
-		err = notificationSender.SendNotifications(ctx, rule, ruleDestinations)
+		err = h.fireRule(ctx, sender, sched, conf, st, rule, now)
 		if err != nil {
-			h.lgr.Error("send notifications error", "rule", rule.Name, "err", err)
 			errs = append(errs, err)
-			continue
 		}
+	}
 
-		err = sched.UpdateRuleState(st, rule.Name, rule.Cron, now)
+	for _, repeat := range sched.GetDueRepeats(conf, st, now) {
+		err = h.sendRepeat(ctx, sender, sched, conf, st, repeat, now)
 		if err != nil {
-			h.lgr.Error("update rule state error", "rule", rule.Name, "err", err)
 			errs = append(errs, err)
-			continue
 		}
 	}
 
-	err = state.SaveState(ctx, h.s3Client, st, h.lgr, *statePath)
+	result.Messages = recorder.messages
+	result.NextWake = sched.NextWakeTime(conf, st, now)
+	result.Changes, err = state.Diff(before, st)
 	if err != nil {
-		return fmt.Errorf("save state: %w", err)
+		return nil, fmt.Errorf("diff state: %w", err)
+	}
+
+	if !opts.DryRun {
+		err = h.saveState(ctx, before, st)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if len(errs) > 0 {
-		return fmt.Errorf("processing errors %+v", errs)
+		return result, fmt.Errorf("processing errors %+v", errs)
 
 	}
 
-	return nil
+	return result, nil
 }
 
-func (h *handler) localRunLoop(ctx context.Context) {
-	t := time.NewTicker(1 * time.Minute)
+// dryRunCommand runs the scheduled pipeline once without sending anything or
+// saving state, and prints what it would have done.
+func (h *handler) dryRunCommand(ctx context.Context, nowOverride string) error {
+	var opts runOptions
+	opts.DryRun = true
+	if nowOverride != "" {
+		t, err := time.Parse(time.RFC3339, nowOverride)
+		if err != nil {
+			return fmt.Errorf("invalid -now, expected RFC 3339: %w", err)
+		}
+		opts.Now = t
+	}
+
+	result, err := h.run(ctx, opts)
+	if result != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if encErr := enc.Encode(result); encErr != nil {
+			return encErr
+		}
+	}
+	return err
+}
 
-	for {
-		err := h.Handler(ctx, events.CloudWatchEvent{})
+func (h *handler) load(ctx context.Context) (*config.Config, *state.State, error) {
+	conf, err := h.loadConfig(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st, err := state.LoadState(ctx, h.s3Client, h.lgr, *statePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load state: %w", err)
+	}
+
+	return conf, st, nil
+}
+
+// maxStateAttempts bounds how many times a state update is retried after
+// another invocation saved the state first.
+const maxStateAttempts = 5
+
+// updateState loads config and state, applies fn and saves the result. If
+// another invocation saved the state in the meantime, it is reloaded and fn
+// applied again, so fn must not do anything but change st.
+func (h *handler) updateState(ctx context.Context, fn func(conf *config.Config, st *state.State) error) error {
+	for attempt := 1; ; attempt++ {
+		conf, st, err := h.load(ctx)
 		if err != nil {
-			h.lgr.Error("handler error", "err", err)
-			os.Exit(1)
+			return err
+		}
+
+		err = fn(conf, st)
+		if err != nil {
+			return err
 		}
 
-		<-t.C
+		err = state.SaveState(ctx, h.s3Client, st, h.lgr, *statePath)
+		if errors.Is(err, state.ErrConflict) && attempt < maxStateAttempts {
+			h.lgr.Info("state changed while updating, retrying", "attempt", attempt)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("save state: %w", err)
+		}
+		return nil
+	}
+}
+
+// saveState saves st, which was loaded as base, once messages have been
+// sent. Starting over would send them again, so if another invocation saved
+// the state in the meantime the changes from base to st are merged into its
+// state instead.
+func (h *handler) saveState(ctx context.Context, base, st *state.State) error {
+	for attempt := 1; ; attempt++ {
+		err := state.SaveState(ctx, h.s3Client, st, h.lgr, *statePath)
+		if !errors.Is(err, state.ErrConflict) || attempt == maxStateAttempts {
+			if err != nil {
+				return fmt.Errorf("save state: %w", err)
+			}
+			return nil
+		}
+
+		h.lgr.Info("state changed since it was loaded, merging", "attempt", attempt)
+
+		theirs, err := state.LoadState(ctx, h.s3Client, h.lgr, *statePath)
+		if err != nil {
+			return fmt.Errorf("reload state: %w", err)
+		}
+		nextBase, err := theirs.Clone()
+		if err != nil {
+			return fmt.Errorf("copy state: %w", err)
+		}
+		st, err = state.Merge(base, st, theirs)
+		if err != nil {
+			return fmt.Errorf("merge state: %w", err)
+		}
+		base = nextBase
+	}
+}
+
+// configNow returns the current time in the config's timezone.
+func (h *handler) configNow(conf *config.Config) (time.Time, error) {
+	now := h.clock.Now()
+	if conf.Timezone != "" {
+		location, err := time.LoadLocation(conf.Timezone)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid timezone %s: %w", conf.Timezone, err)
+		}
+		now = now.In(location)
 	}
+	return now, nil
 }
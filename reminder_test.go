@@ -0,0 +1,797 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/psanford/lambda-reminder/clock"
+	"github.com/psanford/lambda-reminder/config"
+	"github.com/psanford/lambda-reminder/links"
+	"github.com/psanford/lambda-reminder/notifications"
+	"github.com/psanford/lambda-reminder/scheduler"
+	"github.com/psanford/lambda-reminder/state"
+)
+
+// captureSender records sent messages instead of sending them.
+type captureSender struct {
+	*notifications.NotificationSender
+
+	mu   sync.Mutex
+	sent []sentMessage
+
+	// onSend, if set, is called for each message before it is recorded
+	onSend func(msg notifications.Message)
+}
+
+type sentMessage struct {
+	msg          notifications.Message
+	destinations []string
+}
+
+func (c *captureSender) SendMessage(ctx context.Context, msg notifications.Message, destinations []config.Destination) error {
+	if c.onSend != nil {
+		c.onSend(msg)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var ids []string
+	for _, dest := range destinations {
+		ids = append(ids, dest.ID)
+	}
+	c.sent = append(c.sent, sentMessage{msg: msg, destinations: ids})
+	return nil
+}
+
+func (c *captureSender) messages() []sentMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]sentMessage(nil), c.sent...)
+}
+
+// newTestHandler returns a handler using a local config and state file, a
+// fake clock and a capturing sender.
+func newTestHandler(t *testing.T, conf string, clk clock.Clock) (*handler, *captureSender) {
+	t.Helper()
+
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	dir := t.TempDir()
+	confFile := filepath.Join(dir, "config.toml")
+	err := os.WriteFile(confFile, []byte(conf), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldConfig, oldState := *configPath, *statePath
+	*configPath = confFile
+	*statePath = filepath.Join(dir, "state.json")
+	t.Cleanup(func() {
+		*configPath, *statePath = oldConfig, oldState
+	})
+
+	sender := &captureSender{NotificationSender: notifications.NewSender(nil, nil, lgr)}
+	h := &handler{
+		lgr:    lgr,
+		sender: sender,
+		clock:  clk,
+	}
+	return h, sender
+}
+
+const testConfig = `
+timezone = "America/New_York"
+
+[[destination]]
+id = "pager"
+type = "log"
+
+[[rule]]
+name = "standup"
+cron = "0 9 * * *"
+destinations = ["pager"]
+subject = "Standup {{.Occurrence}}"
+body = "Time for standup"
+`
+
+func TestHandlerMultiTick(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk := clock.NewFake(time.Date(2024, 1, 15, 8, 58, 0, 0, ny))
+	h, sender := newTestHandler(t, testConfig, clk)
+	ctx := context.Background()
+
+	tick := func() {
+		t.Helper()
+		err := h.Handler(ctx, events.CloudWatchEvent{})
+		if err != nil {
+			t.Fatalf("Handler() error = %v", err)
+		}
+	}
+
+	// First run only initializes state
+	tick()
+	clk.Advance(time.Minute)
+	tick()
+	if got := len(sender.messages()); got != 0 {
+		t.Fatalf("Expected no messages before 9:00, got %d", got)
+	}
+
+	clk.Advance(time.Minute)
+	tick()
+	clk.Advance(time.Minute)
+	tick()
+
+	msgs := sender.messages()
+	if len(msgs) != 1 {
+		t.Fatalf("Expected 1 message at 9:00, got %d", len(msgs))
+	}
+	if msgs[0].msg.Subject != "Standup 1" {
+		t.Errorf("Expected subject %q, got %q", "Standup 1", msgs[0].msg.Subject)
+	}
+	if len(msgs[0].destinations) != 1 || msgs[0].destinations[0] != "pager" {
+		t.Errorf("Expected message to pager, got %v", msgs[0].destinations)
+	}
+
+	// A missed day of invocations catches up once
+	clk.Set(time.Date(2024, 1, 17, 10, 0, 0, 0, ny))
+	tick()
+	tick()
+
+	msgs = sender.messages()
+	if len(msgs) != 2 {
+		t.Fatalf("Expected 2 messages after catching up, got %d", len(msgs))
+	}
+	if msgs[1].msg.Subject != "Standup 2" {
+		t.Errorf("Expected subject %q, got %q", "Standup 2", msgs[1].msg.Subject)
+	}
+}
+
+func TestEscalationSkipsRegularDestinations(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := `
+timezone = "America/New_York"
+
+[[destination]]
+id = "pager"
+type = "log"
+
+[[destination]]
+id = "manager"
+type = "log"
+
+[[rule]]
+name = "renew_cert"
+cron = "0 9 * * *"
+destinations = ["pager"]
+subject = "Renew the certificate"
+body = "It expires soon"
+repeat_every = "1h"
+repeat_max = 2
+escalate_after = 1
+escalate_destinations = ["pager", "manager"]
+`
+
+	clk := clock.NewFake(time.Date(2024, 1, 15, 8, 59, 0, 0, ny))
+	h, sender := newTestHandler(t, conf, clk)
+	ctx := context.Background()
+
+	for _, at := range []int{8, 9, 10, 11} {
+		clk.Set(time.Date(2024, 1, 15, at, 0, 0, 0, ny))
+		err := h.Handler(ctx, events.CloudWatchEvent{})
+		if err != nil {
+			t.Fatalf("Handler() error = %v", err)
+		}
+	}
+
+	msgs := sender.messages()
+	if len(msgs) != 3 {
+		t.Fatalf("Expected the occurrence and 2 repeats, got %d messages", len(msgs))
+	}
+	if got := strings.Join(msgs[2].destinations, ","); got != "pager,manager" {
+		t.Errorf("Expected the escalated repeat to go to pager once and manager, got %s", got)
+	}
+}
+
+const linksTestConfig = `
+timezone = "America/New_York"
+
+[links]
+base_url = "https://example.com/reminder"
+signing_key = "0123456789abcdef0123456789abcdef"
+
+[[destination]]
+id = "pager"
+type = "log"
+
+[[rule]]
+name = "retro"
+cron = "0 8 * * *"
+destinations = ["pager"]
+subject = "Retro"
+body = "Time for retro"
+
+[[rule]]
+name = "standup"
+cron = "0 9 * * *"
+destinations = ["pager"]
+subject = "Standup"
+body = "Time for standup"
+`
+
+// ackElsewhere acknowledges a rule's pending occurrence the way another
+// invocation would, straight through the state file.
+func ackElsewhere(t *testing.T, h *handler, name string, now time.Time) {
+	t.Helper()
+	ctx := context.Background()
+
+	conf, err := h.loadConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, _ := conf.FindRule(name)
+	st, err := state.LoadState(ctx, nil, h.lgr, *statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = scheduler.New(h.lgr).Acknowledge(st, rule, 0, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.SaveState(ctx, nil, st, h.lgr, *statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunKeepsConcurrentAcknowledgement(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk := clock.NewFake(time.Date(2024, 1, 15, 7, 59, 0, 0, ny))
+	h, sender := newTestHandler(t, linksTestConfig, clk)
+	ctx := context.Background()
+
+	tick := func(hour, min int) {
+		t.Helper()
+		clk.Set(time.Date(2024, 1, 15, hour, min, 0, 0, ny))
+		err := h.Handler(ctx, events.CloudWatchEvent{})
+		if err != nil {
+			t.Fatalf("Handler() error = %v", err)
+		}
+	}
+
+	tick(7, 59)
+	tick(8, 0)
+
+	// Retro is acknowledged while the standup run is sending
+	sender.onSend = func(msg notifications.Message) {
+		if msg.RuleName == "standup" {
+			ackElsewhere(t, h, "retro", clk.Now())
+		}
+	}
+	tick(9, 0)
+	sender.onSend = nil
+	tick(9, 1)
+
+	if got := len(sender.messages()); got != 2 {
+		t.Errorf("Expected retro and standup to be sent once each, got %d messages", got)
+	}
+
+	st, err := state.LoadState(ctx, nil, h.lgr, *statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs := st.Rules["retro"]; rs.Pending != nil || rs.AcknowledgedAt.IsZero() {
+		t.Errorf("Expected retro's acknowledgement to survive the run, got %+v", rs)
+	}
+	if rs := st.Rules["standup"]; rs.Occurrences != 1 || rs.Pending == nil {
+		t.Errorf("Expected standup's occurrence to be recorded, got %+v", rs)
+	}
+}
+
+func TestUpdateStateRetriesOnConflict(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk := clock.NewFake(time.Date(2024, 1, 15, 7, 59, 0, 0, ny))
+	h, _ := newTestHandler(t, linksTestConfig, clk)
+	ctx := context.Background()
+
+	for _, at := range []time.Time{clk.Now(), time.Date(2024, 1, 15, 9, 0, 0, 0, ny)} {
+		clk.Set(at)
+		err := h.Handler(ctx, events.CloudWatchEvent{})
+		if err != nil {
+			t.Fatalf("Handler() error = %v", err)
+		}
+	}
+
+	// Retro is acknowledged by another invocation between our load and
+	// save, so the skip has to be applied again
+	var attempts int
+	err = h.updateRuleState(ctx, "standup", func(sched *scheduler.Scheduler, conf *config.Config, st *state.State, rule config.Rule, now time.Time) error {
+		attempts++
+		if attempts == 1 {
+			ackElsewhere(t, h, "retro", now)
+		}
+		return sched.Skip(st, rule, 2)
+	})
+	if err != nil {
+		t.Fatalf("updateRuleState() error = %v", err)
+	}
+
+	if attempts != 2 {
+		t.Errorf("Expected the update to be applied twice, got %d", attempts)
+	}
+
+	st, err := state.LoadState(ctx, nil, h.lgr, *statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs := st.Rules["retro"]; rs.Pending != nil {
+		t.Errorf("Expected retro's acknowledgement to be kept, got %+v", rs)
+	}
+	if rs := st.Rules["standup"]; rs.SkipRemaining != 2 {
+		t.Errorf("Expected standup's skip to be saved, got %+v", rs)
+	}
+}
+
+func TestDryRunLinksAreInert(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk := clock.NewFake(time.Date(2024, 1, 15, 7, 59, 0, 0, ny))
+	h, _ := newTestHandler(t, linksTestConfig, clk)
+	ctx := context.Background()
+
+	err = h.Handler(ctx, events.CloudWatchEvent{})
+	if err != nil {
+		t.Fatalf("Handler() error = %v", err)
+	}
+
+	result, err := h.run(ctx, runOptions{DryRun: true, Now: time.Date(2024, 1, 15, 8, 0, 0, 0, ny)})
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if len(result.Messages) != 1 || len(result.Messages[0].Payloads) != 1 {
+		t.Fatalf("Expected one recorded message, got %+v", result.Messages)
+	}
+
+	body := result.Messages[0].Payloads[0].Body
+	tokens := regexp.MustCompile(`token=([^\s&]+)`).FindAllStringSubmatch(body, -1)
+	if len(tokens) != len(links.Actions) {
+		t.Fatalf("Expected %d links in the dry run body, got %q", len(links.Actions), body)
+	}
+	for _, m := range tokens {
+		_, err := links.Verify([]byte("0123456789abcdef0123456789abcdef"), m[1], clk.Now())
+		if !errors.Is(err, links.ErrInvalidToken) {
+			t.Errorf("Expected dry run link not to verify with the real key, got %v", err)
+		}
+	}
+
+	// The cached config keeps its real key
+	conf, err := h.loadConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Links.SigningKey != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("Expected the loaded config's signing key to be unchanged")
+	}
+}
+
+func TestLocalRunLoop(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk := clock.NewFake(time.Date(2024, 1, 15, 8, 58, 0, 0, ny))
+	h, sender := newTestHandler(t, testConfig, clk)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- h.localRunLoop(ctx)
+	}()
+
+	for range 3 {
+		clk.BlockUntil(1)
+		clk.Advance(time.Minute)
+	}
+	clk.BlockUntil(1)
+
+	msgs := sender.messages()
+	if len(msgs) != 1 {
+		t.Fatalf("Expected 1 message after running through 9:00, got %d", len(msgs))
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("localRunLoop() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected localRunLoop to return after cancel")
+	}
+}
+
+func TestLocalRunLoopRecoversFromErrors(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk := clock.NewFake(time.Date(2024, 1, 15, 8, 58, 0, 0, ny))
+	h, sender := newTestHandler(t, testConfig, clk)
+
+	err = os.WriteFile(*configPath, []byte("not [valid toml"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- h.localRunLoop(ctx)
+	}()
+
+	// Two failures back off 5s then 10s instead of exiting
+	clk.BlockUntil(1)
+	clk.Advance(minBackoff)
+	clk.BlockUntil(1)
+
+	select {
+	case err := <-done:
+		t.Fatalf("Expected loop to keep running after errors, returned %v", err)
+	default:
+	}
+
+	err = os.WriteFile(*configPath, []byte(testConfig), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Advance(2 * minBackoff)
+	clk.BlockUntil(1)
+	clk.Set(time.Date(2024, 1, 15, 9, 0, 0, 0, ny))
+	clk.BlockUntil(1)
+
+	if got := len(sender.messages()); got != 1 {
+		t.Fatalf("Expected 1 message once the config was fixed, got %d", got)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("localRunLoop() error = %v", err)
+	}
+}
+
+func TestLocalRunLoopWakesOnConfigChange(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk := clock.NewFake(time.Date(2024, 1, 15, 7, 0, 0, 0, ny))
+	h, _ := newTestHandler(t, testConfig, clk)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- h.localRunLoop(ctx)
+	}()
+	clk.BlockUntil(1)
+
+	updated := testConfig + `
+[[rule]]
+name = "added"
+cron = "30 7 * * *"
+destinations = ["pager"]
+subject = "Added"
+body = "Added rule"
+`
+	err = os.WriteFile(*configPath, []byte(updated), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The watcher reloads the config and wakes the loop, well before the
+	// 9:00 standup that would otherwise be the next wake up
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, err := os.ReadFile(*statePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), `"added"`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected state for the added rule after the config changed, got %s", data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("localRunLoop() error = %v", err)
+	}
+}
+
+func TestReloadConfigKeepsLastGood(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 15, 7, 0, 0, 0, time.UTC))
+	h, _ := newTestHandler(t, testConfig, clk)
+	h.watchingConfig = true
+	h.configReloaded = make(chan struct{}, 1)
+	ctx := context.Background()
+
+	conf, err := h.loadConfig(ctx)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+
+	err = os.WriteFile(*configPath, []byte(testConfig+"\n[[rule]]\nname = \"broken\"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.reloadConfig(ctx); err == nil {
+		t.Fatal("Expected reload of an invalid config to fail")
+	}
+
+	got, err := h.loadConfig(ctx)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if got != conf {
+		t.Error("Expected last known-good config to stay in use after an invalid edit")
+	}
+	select {
+	case <-h.configReloaded:
+		t.Error("Expected no wake up for a rejected config")
+	default:
+	}
+
+	err = os.WriteFile(*configPath, []byte(strings.Replace(testConfig, "0 9 * * *", "0 10 * * *", 1)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.reloadConfig(ctx); err != nil {
+		t.Fatalf("reloadConfig() error = %v", err)
+	}
+
+	got, err = h.loadConfig(ctx)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if got.Rules[0].Cron != "0 10 * * *" {
+		t.Errorf("Expected reloaded config to be used, got cron %q", got.Rules[0].Cron)
+	}
+	select {
+	case <-h.configReloaded:
+	default:
+		t.Error("Expected a wake up after a successful reload")
+	}
+}
+
+func TestConfigCacheKeepsLastGood(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withErrors := "error_destinations = [\"pager\"]\n" + testConfig
+
+	clk := clock.NewFake(time.Date(2024, 1, 15, 8, 59, 0, 0, ny))
+	h, sender := newTestHandler(t, withErrors, clk)
+	ctx := context.Background()
+
+	tick := func() {
+		t.Helper()
+		err := h.Handler(ctx, events.CloudWatchEvent{})
+		if err != nil {
+			t.Fatalf("Handler() error = %v", err)
+		}
+	}
+
+	tick()
+	good := h.conf
+
+	// An unchanged config isn't decoded again
+	tick()
+	if h.conf != good {
+		t.Error("Expected cached config to be reused when unchanged")
+	}
+
+	err = os.WriteFile(*configPath, []byte(withErrors+"\n[[rule]]\nname = \"broken\"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tick()
+	tick()
+
+	msgs := sender.messages()
+	if len(msgs) != 1 {
+		t.Fatalf("Expected one error notification for the bad config, got %d", len(msgs))
+	}
+	if msgs[0].msg.Subject != "Reminder config failed to load" || msgs[0].destinations[0] != "pager" {
+		t.Errorf("Unexpected error notification %+v", msgs[0])
+	}
+
+	// Reminders keep running on the last good config
+	clk.Advance(time.Minute)
+	tick()
+	msgs = sender.messages()
+	if len(msgs) != 2 || msgs[1].msg.Subject != "Standup 1" {
+		t.Fatalf("Expected standup to fire with the last good config, got %+v", msgs)
+	}
+
+	fixed := strings.Replace(withErrors, "0 9 * * *", "0 10 * * *", 1)
+	err = os.WriteFile(*configPath, []byte(fixed), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tick()
+	if h.conf == good || h.conf.Rules[0].Cron != "0 10 * * *" {
+		t.Error("Expected the fixed config to replace the cached one")
+	}
+}
+
+// rotatingResolver resolves every reference to its current value.
+type rotatingResolver struct {
+	mu    sync.Mutex
+	value string
+}
+
+func (r *rotatingResolver) set(v string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.value = v
+}
+
+func (r *rotatingResolver) Resolve(ctx context.Context, ref config.Reference) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.value, nil
+}
+
+func TestConfigCacheRefreshesSecrets(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := strings.Replace(testConfig, `body = "Time for standup"`, `body = "Join ${test:room}"`, 1)
+	clk := clock.NewFake(time.Date(2024, 1, 15, 8, 0, 0, 0, ny))
+	h, _ := newTestHandler(t, conf, clk)
+	resolver := &rotatingResolver{value: "old-room-link"}
+	h.loader = &config.Loader{Resolvers: map[string]config.Resolver{"test": resolver}}
+	ctx := context.Background()
+
+	loaded, err := h.loadConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Rules[0].Body != "Join old-room-link" {
+		t.Fatalf("Expected the reference to be resolved, got %q", loaded.Rules[0].Body)
+	}
+
+	resolver.set("new-room-link")
+
+	loaded, err = h.loadConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Rules[0].Body != "Join old-room-link" {
+		t.Errorf("Expected the cached secret before the refresh interval, got %q", loaded.Rules[0].Body)
+	}
+
+	clk.Advance(secretRefreshInterval)
+	loaded, err = h.loadConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Rules[0].Body != "Join new-room-link" {
+		t.Errorf("Expected the rotated secret after the refresh interval, got %q", loaded.Rules[0].Body)
+	}
+}
+
+func TestConvertLeavesLoaderAlone(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC))
+	conf := strings.Replace(testConfig, `body = "Time for standup"`, `body = "Join ${test:room}"`, 1)
+	h, _ := newTestHandler(t, conf, clk)
+	h.loader = &config.Loader{Resolvers: map[string]config.Resolver{"test": &rotatingResolver{value: "room-link"}}}
+
+	var buf strings.Builder
+	err := h.convertCommand(context.Background(), &buf, "", "yaml")
+	if err != nil {
+		t.Fatalf("convertCommand() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "${test:room}") {
+		t.Errorf("Expected convert to keep the reference, got\n%s", buf.String())
+	}
+	if h.loader.Resolvers == nil {
+		t.Errorf("Expected convert not to clear the handler's resolvers")
+	}
+}
+
+func TestConfigErrorNotificationDoesNotHoldLock(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC))
+	withErrors := "error_destinations = [\"pager\"]\n" + testConfig
+	h, sender := newTestHandler(t, withErrors, clk)
+	ctx := context.Background()
+
+	_, err := h.loadConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(*configPath, []byte(withErrors+"\n[[rule]]\nname = \"broken\"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sending := make(chan struct{})
+	release := make(chan struct{})
+	sender.onSend = func(msg notifications.Message) {
+		close(sending)
+		<-release
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := h.loadConfig(ctx)
+		done <- err
+	}()
+	<-sending
+
+	loaded := make(chan error, 1)
+	go func() {
+		_, err := h.loadConfig(ctx)
+		loaded <- err
+	}()
+	select {
+	case err := <-loaded:
+		if err != nil {
+			t.Errorf("Expected the cached config while the notification is sending, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected loadConfig not to wait for the config error notification")
+	}
+
+	close(release)
+	err = <-done
+	if err != nil {
+		t.Errorf("Expected the cached config after the notification, got %v", err)
+	}
+	if msgs := sender.messages(); len(msgs) != 1 {
+		t.Errorf("Expected one config error notification, got %d", len(msgs))
+	}
+}
@@ -0,0 +1,53 @@
+package scheduler
+
+import "time"
+
+// Cron schedules are matched against wall clock time in the location of the
+// time they are computed from. Daylight saving transitions make some wall
+// clock times nonexistent (spring forward) and others ambiguous (fall back).
+// The scheduler resolves them as follows:
+//
+//   - A nonexistent time fires at the shifted instant, i.e. the wall time
+//     read with the offset from before the transition. 02:30 on a day that
+//     jumps from 02:00 to 03:00 fires at 03:30.
+//   - An ambiguous time fires once, at its first instance.
+//
+// Both are implemented by doing cron matching on wall clock times with no
+// location, then mapping the result back with fromWallClock.
+
+// wallClock returns t's local date and time as the same date and time in
+// UTC, which has no DST transitions.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// transitionMargin is far enough from any wall time that the offsets at
+// wall-margin and wall+margin bracket any transition affecting it. Zone
+// offsets are within ±14h and transitions are months apart.
+const transitionMargin = 36 * time.Hour
+
+// fromWallClock returns the instant in loc whose local date and time is wall
+// (as returned by wallClock), resolving DST gaps and overlaps as described
+// above. time.Date leaves the choice unspecified for these cases.
+func fromWallClock(wall time.Time, loc *time.Location) time.Time {
+	_, offBefore := wall.Add(-transitionMargin).In(loc).Zone()
+	_, offAfter := wall.Add(transitionMargin).In(loc).Zone()
+
+	var result time.Time
+	for _, off := range []int{offBefore, offAfter} {
+		candidate := wall.Add(-time.Duration(off) * time.Second).In(loc)
+		if _, got := candidate.Zone(); got != off {
+			continue
+		}
+		if result.IsZero() || candidate.Before(result) {
+			result = candidate
+		}
+	}
+
+	if result.IsZero() {
+		// wall falls in a gap; read it with the offset from before the gap
+		result = wall.Add(-time.Duration(offBefore) * time.Second).In(loc)
+	}
+
+	return result
+}
@@ -0,0 +1,55 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const intervalPrefix = "@every "
+
+// isInterval reports whether a schedule expression is a fixed interval of the
+// form "@every <duration> from <RFC 3339 anchor>".
+func isInterval(expr string) bool {
+	return strings.HasPrefix(expr, intervalPrefix)
+}
+
+func parseInterval(expr string) (every time.Duration, anchor time.Time, err error) {
+	rest := strings.TrimPrefix(expr, intervalPrefix)
+	everyStr, anchorStr, ok := strings.Cut(rest, " from ")
+	if !ok {
+		return 0, time.Time{}, fmt.Errorf("expected \"@every <duration> from <anchor>\"")
+	}
+
+	every, err = time.ParseDuration(everyStr)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if every <= 0 {
+		return 0, time.Time{}, fmt.Errorf("interval must be positive")
+	}
+
+	anchor, err = time.Parse(time.RFC3339, anchorStr)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return every, anchor, nil
+}
+
+// nextIntervalTime returns the first anchor + n*every after fromTime.
+// Occurrences are computed from the anchor rather than the last run, so a
+// late run never shifts later occurrences.
+func (s *Scheduler) nextIntervalTime(expr string, fromTime time.Time) (time.Time, error) {
+	every, anchor, err := parseInterval(expr)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid interval schedule %q: %w", expr, err)
+	}
+
+	if fromTime.Before(anchor) {
+		return anchor.In(fromTime.Location()), nil
+	}
+
+	n := fromTime.Sub(anchor)/every + 1
+	return anchor.Add(n * every).In(fromTime.Location()), nil
+}
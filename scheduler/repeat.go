@@ -0,0 +1,167 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/psanford/lambda-reminder/config"
+	"github.com/psanford/lambda-reminder/state"
+)
+
+// Repeat is an unacknowledged occurrence that is due to be re-sent.
+type Repeat struct {
+	Rule       config.Rule
+	Occurrence int
+	// Repeat is the 1-based number of this repeat of the occurrence.
+	Repeat int
+	// Escalate is set once the rule's escalate_after threshold is reached.
+	Escalate bool
+}
+
+// Destinations returns the ids of the destinations the repeat is sent to:
+// the rule's destinations, plus its escalation destinations once escalated.
+// Each destination appears once, so one listed as both a regular and an
+// escalation destination isn't sent the repeat twice.
+func (r Repeat) Destinations() []string {
+	if !r.Escalate {
+		return r.Rule.Destinations
+	}
+
+	var dests []string
+	seen := make(map[string]bool)
+	for _, list := range [][]string{r.Rule.Destinations, r.Rule.EscalateDestinations} {
+		for _, id := range list {
+			if !seen[id] {
+				seen[id] = true
+				dests = append(dests, id)
+			}
+		}
+	}
+	return dests
+}
+
+// GetDueRepeats returns pending occurrences whose next repeat time has
+// passed. Rules that are inactive don't repeat.
+func (s *Scheduler) GetDueRepeats(conf *config.Config, st *state.State, now time.Time) []Repeat {
+	var repeats []Repeat
+
+	for _, rule := range conf.Rules {
+		ruleState, exists := st.Rules[rule.StateKey()]
+		if !exists || ruleState.Pending == nil || !rule.ActiveAt(now) {
+			continue
+		}
+
+		pending := ruleState.Pending
+		if pending.NextRepeatAt.IsZero() || now.Before(pending.NextRepeatAt) {
+			continue
+		}
+
+		repeat := pending.Repeats + 1
+		repeats = append(repeats, Repeat{
+			Rule:       rule,
+			Occurrence: pending.Occurrence,
+			Repeat:     repeat,
+			Escalate:   rule.EscalateAfter > 0 && repeat > rule.EscalateAfter,
+		})
+	}
+
+	return repeats
+}
+
+// RecordRepeat updates the rule's pending occurrence after a repeat was
+// sent, scheduling the next repeat until repeat_max is reached.
+func (s *Scheduler) RecordRepeat(st *state.State, rule config.Rule, sentAt time.Time) error {
+	key := rule.StateKey()
+	ruleState := st.Rules[key]
+	if ruleState.Pending == nil {
+		return fmt.Errorf("rule %s has no pending occurrence", rule.Name)
+	}
+
+	pending := *ruleState.Pending
+	pending.Repeats++
+	pending.LastSentAt = sentAt
+	pending.NextRepeatAt = time.Time{}
+	if rule.RepeatEvery.Duration > 0 && pending.Repeats < rule.RepeatMax {
+		pending.NextRepeatAt = sentAt.Add(rule.RepeatEvery.Duration)
+	} else if rule.RepeatEvery.Duration > 0 {
+		s.lgr.Info("rule reached max repeats", "rule", rule.Name, "occurrence", pending.Occurrence)
+	}
+
+	ruleState.Pending = &pending
+	st.Rules[key] = ruleState
+	return nil
+}
+
+// Acknowledge marks the rule's pending occurrence as acknowledged, stopping
+// further repeats. An occurrence of zero acknowledges whichever occurrence is
+// pending; otherwise it must match the pending one.
+func (s *Scheduler) Acknowledge(st *state.State, rule config.Rule, occurrence int, now time.Time) error {
+	key := rule.StateKey()
+	ruleState, exists := st.Rules[key]
+	if !exists || ruleState.Pending == nil {
+		return fmt.Errorf("rule %s has no pending occurrence", rule.Name)
+	}
+
+	if occurrence != 0 && occurrence != ruleState.Pending.Occurrence {
+		return fmt.Errorf("rule %s occurrence %d is not pending, occurrence %d is", rule.Name, occurrence, ruleState.Pending.Occurrence)
+	}
+
+	s.lgr.Info("occurrence acknowledged", "rule", rule.Name, "occurrence", ruleState.Pending.Occurrence)
+
+	ruleState.Pending = nil
+	ruleState.AcknowledgedAt = now
+	st.Rules[key] = ruleState
+	return nil
+}
+
+// Snooze re-sends the rule's pending occurrence at until. It works for rules
+// without repeat_every too, in which case the occurrence is re-sent once.
+// Occurrence is matched as in Acknowledge. With an occurrence of zero and
+// nothing waiting for a repeat or acknowledgement, the rule's next
+// occurrence is held back until then instead.
+func (s *Scheduler) Snooze(conf *config.Config, st *state.State, rule config.Rule, occurrence int, until time.Time) error {
+	key := rule.StateKey()
+	ruleState := st.Rules[key]
+
+	waiting := ruleState.Pending != nil && (!ruleState.Pending.NextRepeatAt.IsZero() || awaitsAck(conf))
+	if ruleState.Pending == nil || (occurrence == 0 && !waiting) {
+		if occurrence != 0 {
+			return fmt.Errorf("rule %s has no pending occurrence", rule.Name)
+		}
+
+		s.lgr.Info("next occurrence snoozed", "rule", rule.Name, "until", until)
+
+		ruleState.SnoozedUntil = until
+		st.Rules[key] = ruleState
+		return nil
+	}
+
+	if occurrence != 0 && occurrence != ruleState.Pending.Occurrence {
+		return fmt.Errorf("rule %s occurrence %d is not pending, occurrence %d is", rule.Name, occurrence, ruleState.Pending.Occurrence)
+	}
+
+	s.lgr.Info("occurrence snoozed", "rule", rule.Name, "occurrence", ruleState.Pending.Occurrence, "until", until)
+
+	pending := *ruleState.Pending
+	pending.NextRepeatAt = until
+	ruleState.Pending = &pending
+	st.Rules[key] = ruleState
+	return nil
+}
+
+// Skip skips the rule's next count occurrences without sending them. A count
+// of zero cancels an earlier skip.
+func (s *Scheduler) Skip(st *state.State, rule config.Rule, count int) error {
+	if count < 0 {
+		return fmt.Errorf("skip count cannot be negative")
+	}
+
+	key := rule.StateKey()
+	ruleState := st.Rules[key]
+
+	s.lgr.Info("skipping upcoming occurrences", "rule", rule.Name, "count", count)
+
+	ruleState.SkipRemaining = count
+	st.Rules[key] = ruleState
+	return nil
+}
@@ -0,0 +1,320 @@
+package scheduler
+
+import (
+	"log/slog"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/psanford/lambda-reminder/config"
+	"github.com/psanford/lambda-reminder/state"
+)
+
+func TestRepeatUntilAcknowledged(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	rule := config.Rule{
+		Name:                 "renew_cert",
+		Cron:                 "0 9 1 * *",
+		RepeatEvery:          config.Duration{Duration: time.Hour},
+		RepeatMax:            3,
+		EscalateAfter:        2,
+		EscalateDestinations: []string{"manager"},
+	}
+	conf := &config.Config{Rules: []config.Rule{rule}}
+	st := &state.State{
+		Rules: make(map[string]state.RuleState),
+	}
+
+	fired := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	err := s.UpdateRuleState(conf, st, rule, fired)
+	if err != nil {
+		t.Fatalf("UpdateRuleState() error = %v", err)
+	}
+
+	if repeats := s.GetDueRepeats(conf, st, fired.Add(59*time.Minute)); len(repeats) != 0 {
+		t.Fatalf("Expected no repeats before repeat_every, got %d", len(repeats))
+	}
+
+	var sent []Repeat
+	for now := fired; now.Before(fired.Add(6 * time.Hour)); now = now.Add(time.Minute) {
+		for _, repeat := range s.GetDueRepeats(conf, st, now) {
+			sent = append(sent, repeat)
+			err = s.RecordRepeat(st, repeat.Rule, now)
+			if err != nil {
+				t.Fatalf("RecordRepeat() error = %v", err)
+			}
+		}
+	}
+
+	if len(sent) != 3 {
+		t.Fatalf("Expected 3 repeats, got %d", len(sent))
+	}
+	for i, repeat := range sent {
+		if repeat.Repeat != i+1 || repeat.Occurrence != 1 {
+			t.Errorf("Repeat %d: got repeat %d of occurrence %d", i, repeat.Repeat, repeat.Occurrence)
+		}
+		wantEscalate := i+1 > 2
+		if repeat.Escalate != wantEscalate {
+			t.Errorf("Repeat %d: escalate = %v, want %v", i+1, repeat.Escalate, wantEscalate)
+		}
+	}
+
+	if err := s.Acknowledge(st, rule, 2, fired); err == nil {
+		t.Error("Expected error acknowledging an occurrence that isn't pending")
+	}
+
+	ackTime := fired.Add(6 * time.Hour)
+	err = s.Acknowledge(st, rule, 1, ackTime)
+	if err != nil {
+		t.Fatalf("Acknowledge() error = %v", err)
+	}
+
+	rs := st.Rules["renew_cert"]
+	if rs.Pending != nil {
+		t.Error("Expected pending occurrence to be cleared")
+	}
+	if !rs.AcknowledgedAt.Equal(ackTime) {
+		t.Errorf("Expected acknowledged at %v, got %v", ackTime, rs.AcknowledgedAt)
+	}
+
+	if err := s.Acknowledge(st, rule, 0, ackTime); err == nil {
+		t.Error("Expected error acknowledging with nothing pending")
+	}
+}
+
+func TestRepeatDestinations(t *testing.T) {
+	rule := config.Rule{
+		Name:                 "renew_cert",
+		Destinations:         []string{"pager", "manager"},
+		EscalateDestinations: []string{"manager", "director"},
+	}
+
+	if got := (Repeat{Rule: rule}).Destinations(); !reflect.DeepEqual(got, []string{"pager", "manager"}) {
+		t.Errorf("Expected only the regular destinations before escalating, got %v", got)
+	}
+
+	want := []string{"pager", "manager", "director"}
+	if got := (Repeat{Rule: rule, Escalate: true}).Destinations(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected escalated destinations %v, got %v", want, got)
+	}
+}
+
+func TestAcknowledgeStopsRepeats(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	rule := config.Rule{
+		Name:        "renew_cert",
+		Cron:        "0 9 1 * *",
+		RepeatEvery: config.Duration{Duration: time.Hour},
+		RepeatMax:   10,
+	}
+	conf := &config.Config{Rules: []config.Rule{rule}}
+	st := &state.State{
+		Rules: make(map[string]state.RuleState),
+	}
+
+	fired := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	err := s.UpdateRuleState(conf, st, rule, fired)
+	if err != nil {
+		t.Fatalf("UpdateRuleState() error = %v", err)
+	}
+
+	err = s.Acknowledge(st, rule, 0, fired.Add(30*time.Minute))
+	if err != nil {
+		t.Fatalf("Acknowledge() error = %v", err)
+	}
+
+	if repeats := s.GetDueRepeats(conf, st, fired.Add(2*time.Hour)); len(repeats) != 0 {
+		t.Errorf("Expected no repeats after acknowledgement, got %d", len(repeats))
+	}
+}
+
+func TestSnooze(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	// No repeat_every, so the occurrence is only re-sent when snoozed. It
+	// is pending because notifications carry snooze links.
+	rule := config.Rule{
+		Name: "water_plants",
+		Cron: "0 9 * * *",
+	}
+	conf := &config.Config{
+		Rules: []config.Rule{rule},
+		Links: &config.Links{BaseURL: "https://example.com/", SigningKey: "0123456789abcdef0123456789abcdef"},
+	}
+	st := &state.State{
+		Rules: make(map[string]state.RuleState),
+	}
+
+	if err := s.Snooze(conf, st, rule, 1, time.Now()); err == nil {
+		t.Error("Expected error snoozing an occurrence that isn't pending")
+	}
+
+	fired := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	err := s.UpdateRuleState(conf, st, rule, fired)
+	if err != nil {
+		t.Fatalf("UpdateRuleState() error = %v", err)
+	}
+
+	until := fired.Add(time.Hour)
+	err = s.Snooze(conf, st, rule, 1, until)
+	if err != nil {
+		t.Fatalf("Snooze() error = %v", err)
+	}
+
+	if repeats := s.GetDueRepeats(conf, st, until.Add(-time.Minute)); len(repeats) != 0 {
+		t.Fatalf("Expected no repeats before snooze ends, got %d", len(repeats))
+	}
+
+	repeats := s.GetDueRepeats(conf, st, until)
+	if len(repeats) != 1 || repeats[0].Occurrence != 1 {
+		t.Fatalf("Expected occurrence 1 to be re-sent when snooze ends, got %+v", repeats)
+	}
+
+	err = s.RecordRepeat(st, rule, until)
+	if err != nil {
+		t.Fatalf("RecordRepeat() error = %v", err)
+	}
+
+	if repeats := s.GetDueRepeats(conf, st, until.Add(24*time.Hour)); len(repeats) != 0 {
+		t.Errorf("Expected a snoozed occurrence to be re-sent once, got %d more", len(repeats))
+	}
+}
+
+func TestSkipAndSnoozeNext(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	rule := config.Rule{
+		Name: "standup",
+		Cron: "0 9 * * *",
+	}
+	conf := &config.Config{Rules: []config.Rule{rule}}
+	st := &state.State{
+		Rules: map[string]state.RuleState{
+			"standup": {
+				Name:        "standup",
+				CronExpr:    "0 9 * * *",
+				NextRunTime: time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	err := s.Skip(st, rule, 2)
+	if err != nil {
+		t.Fatalf("Skip() error = %v", err)
+	}
+
+	var fired []time.Time
+	start := time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC)
+	for now := start; now.Before(start.Add(72 * time.Hour)); now = now.Add(time.Minute) {
+		due, err := s.GetDueRules(conf, st, now)
+		if err != nil {
+			t.Fatalf("GetDueRules() error = %v", err)
+		}
+		for _, r := range due {
+			fired = append(fired, now)
+			if err := s.UpdateRuleState(conf, st, r, now); err != nil {
+				t.Fatalf("UpdateRuleState() error = %v", err)
+			}
+		}
+	}
+
+	want := time.Date(2024, 1, 17, 9, 0, 0, 0, time.UTC)
+	if len(fired) != 1 || !fired[0].Equal(want) {
+		t.Fatalf("Expected only %v to fire after skipping 2, got %v", want, fired)
+	}
+
+	rs := st.Rules["standup"]
+	if rs.SkipRemaining != 0 {
+		t.Errorf("Expected no skips remaining, got %d", rs.SkipRemaining)
+	}
+	if rs.Occurrences != 1 {
+		t.Errorf("Expected skipped occurrences not to be counted, got %d occurrences", rs.Occurrences)
+	}
+
+	if rs.Pending != nil {
+		t.Errorf("Expected nothing pending without repeats or links, got %+v", rs.Pending)
+	}
+
+	// Snoozing with nothing pending holds back the next occurrence
+	until := time.Date(2024, 1, 18, 13, 30, 0, 0, time.UTC)
+	err = s.Snooze(conf, st, rule, 0, until)
+	if err != nil {
+		t.Fatalf("Snooze() error = %v", err)
+	}
+
+	next := time.Date(2024, 1, 18, 9, 0, 0, 0, time.UTC)
+	if due, _ := s.GetDueRules(conf, st, next); len(due) != 0 {
+		t.Fatal("Expected snoozed occurrence not to be due at its scheduled time")
+	}
+
+	due, _ := s.GetDueRules(conf, st, until)
+	if len(due) != 1 {
+		t.Fatal("Expected snoozed occurrence to be due when the snooze ends")
+	}
+	if err := s.UpdateRuleState(conf, st, rule, until); err != nil {
+		t.Fatalf("UpdateRuleState() error = %v", err)
+	}
+
+	rs = st.Rules["standup"]
+	if !rs.SnoozedUntil.IsZero() {
+		t.Errorf("Expected snooze to be cleared after firing, got %v", rs.SnoozedUntil)
+	}
+	if wantNext := time.Date(2024, 1, 19, 9, 0, 0, 0, time.UTC); !rs.NextRunTime.Equal(wantNext) {
+		t.Errorf("Expected schedule to resume at %v, got %v", wantNext, rs.NextRunTime)
+	}
+}
+
+func TestSnoozeFiredRule(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	rule := config.Rule{
+		Name: "standup",
+		Cron: "0 9 * * *",
+	}
+	conf := &config.Config{Rules: []config.Rule{rule}}
+
+	fired := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
+	until := time.Date(2024, 1, 16, 13, 30, 0, 0, time.UTC)
+
+	for _, stale := range []bool{false, true} {
+		st := &state.State{
+			Rules: make(map[string]state.RuleState),
+		}
+		err := s.UpdateRuleState(conf, st, rule, fired)
+		if err != nil {
+			t.Fatalf("UpdateRuleState() error = %v", err)
+		}
+		if stale {
+			// State saved by older versions kept every fired occurrence
+			// pending
+			rs := st.Rules["standup"]
+			rs.Pending = &state.PendingOccurrence{Occurrence: 1, FirstSentAt: fired, LastSentAt: fired}
+			st.Rules["standup"] = rs
+		}
+
+		err = s.Snooze(conf, st, rule, 0, until)
+		if err != nil {
+			t.Fatalf("Snooze() error = %v", err)
+		}
+
+		if repeats := s.GetDueRepeats(conf, st, until); len(repeats) != 0 {
+			t.Errorf("stale=%v: Expected the fired occurrence not to be re-sent, got %+v", stale, repeats)
+		}
+
+		next := time.Date(2024, 1, 16, 9, 0, 0, 0, time.UTC)
+		if due, _ := s.GetDueRules(conf, st, next); len(due) != 0 {
+			t.Errorf("stale=%v: Expected the next occurrence to be held back at its scheduled time", stale)
+		}
+		if due, _ := s.GetDueRules(conf, st, until); len(due) != 1 {
+			t.Errorf("stale=%v: Expected the next occurrence to be due when the snooze ends", stale)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/psanford/lambda-reminder/config"
+)
+
+// isRRule reports whether a schedule expression is an RFC 5545 recurrence
+// rule rather than a cron expression.
+func isRRule(expr string) bool {
+	expr = strings.TrimSpace(expr)
+	return strings.HasPrefix(expr, "DTSTART") || strings.HasPrefix(expr, "RRULE")
+}
+
+func (s *Scheduler) nextRRuleTime(expr string, fromTime time.Time) (time.Time, error) {
+	set, err := config.ParseRRule(expr, fromTime.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid rrule: %w", err)
+	}
+
+	nextTime := set.After(fromTime, false)
+	if nextTime.IsZero() {
+		return time.Time{}, fmt.Errorf("rrule %q: %w", expr, ErrNoMoreOccurrences)
+	}
+
+	return nextTime.In(fromTime.Location()), nil
+}
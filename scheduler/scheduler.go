@@ -1,7 +1,9 @@
 package scheduler
 
 import (
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"log/slog"
 	"time"
 
@@ -23,27 +25,171 @@ func New(lgr *slog.Logger) *Scheduler {
 }
 
 func (s *Scheduler) ValidateRule(rule *config.Rule) error {
+	if rule.Every.Duration != 0 {
+		_, _, err := parseInterval(rule.ScheduleExpr())
+		if err != nil {
+			return fmt.Errorf("invalid every schedule: %w", err)
+		}
+		return nil
+	}
+
+	if rule.RRule != "" {
+		_, err := config.ParseRRule(rule.RRule, time.UTC)
+		if err != nil {
+			return fmt.Errorf("invalid rrule: %w", err)
+		}
+		return nil
+	}
+
 	if !s.cron.IsValid(rule.Cron) {
 		return fmt.Errorf("invalid cron expression: %s", rule.Cron)
 	}
 	return nil
 }
 
+// ErrNoMoreOccurrences is returned when a schedule has no occurrences left,
+// such as an RRULE with COUNT or UNTIL after its last occurrence. It is a
+// normal end state: the rule is marked finished rather than failing.
+var ErrNoMoreOccurrences = errors.New("no more occurrences")
+
+// GetNextRunTime returns the first time after fromTime matched by a schedule
+// expression, which is a cron expression, an RRULE with DTSTART or a fixed
+// interval (see config.Rule.ScheduleExpr).
 func (s *Scheduler) GetNextRunTime(cronExpr string, fromTime time.Time) (time.Time, error) {
+	if isRRule(cronExpr) {
+		return s.nextRRuleTime(cronExpr, fromTime)
+	}
+	if isInterval(cronExpr) {
+		return s.nextIntervalTime(cronExpr, fromTime)
+	}
+
 	if !s.cron.IsValid(cronExpr) {
 		return time.Time{}, fmt.Errorf("invalid cron expression: %s", cronExpr)
 	}
 
-	nextTime, err := gronx.NextTickAfter(cronExpr, fromTime, false)
+	// Match on wall clock time so DST transitions are handled explicitly,
+	// see dst.go. A wall time can map to an instant at or before fromTime
+	// when fromTime is in the second copy of a fall back hour, since
+	// ambiguous times resolve to their first instance.
+	cursor := wallClock(fromTime)
+	for {
+		nextWall, err := gronx.NextTickAfter(cronExpr, cursor, false)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("calculate next run time: %w", err)
+		}
+
+		if nextWall.IsZero() {
+			return time.Time{}, fmt.Errorf("no next run time found for cron: %s", cronExpr)
+		}
+
+		nextTime := fromWallClock(nextWall, fromTime.Location())
+		if nextTime.After(fromTime) {
+			return nextTime, nil
+		}
+
+		// Every wall time up to the end of the overlap has already passed,
+		// so jump to the last wall time before the transition
+		cursor = nextWall
+		if start, _ := fromTime.ZoneBounds(); !start.IsZero() {
+			overlapEnd := wallClock(start.Add(-time.Second))
+			if overlapEnd.After(cursor) {
+				cursor = overlapEnd
+			}
+		}
+	}
+}
+
+// maxCalendarSearch bounds how many occurrences GetRuleNextRunTime will
+// look through for one that isn't on a skip calendar date.
+const maxCalendarSearch = 1000
+
+// GetRuleNextRunTime returns the rule's next run time after fromTime,
+// taking its skip calendars, calendar policy and jitter into account.
+func (s *Scheduler) GetRuleNextRunTime(rule config.Rule, fromTime time.Time) (time.Time, error) {
+	next, err := s.nextScheduledTime(rule, fromTime)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("calculate next run time: %w", err)
+		return time.Time{}, err
+	}
+
+	if rule.Jitter.Duration <= 0 {
+		return next, nil
 	}
 
-	if nextTime.IsZero() {
-		return time.Time{}, fmt.Errorf("no next run time found for cron: %s", cronExpr)
+	// Jitter is capped at the time until the following occurrence, so
+	// occurrences can't collide or fire out of order
+	period := rule.Jitter.Duration
+	following, err := s.nextScheduledTime(rule, next)
+	if err == nil {
+		period = following.Sub(next)
+	} else if !errors.Is(err, ErrNoMoreOccurrences) {
+		return time.Time{}, err
 	}
 
-	return nextTime, nil
+	return next.Add(jitterOffset(rule, next, period)), nil
+}
+
+// jitterOffset returns a pseudo-random delay in [0, min(rule.Jitter, period))
+// with one second resolution. It is seeded from the rule and the scheduled
+// time of the occurrence, so recomputing it always gives the same fire time.
+func jitterOffset(rule config.Rule, scheduled time.Time, period time.Duration) time.Duration {
+	seconds := uint64(min(rule.Jitter.Duration, period) / time.Second)
+	if seconds == 0 {
+		return 0
+	}
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%d", rule.StateKey(), scheduled.Unix())
+	return time.Duration(h.Sum64()%seconds) * time.Second
+}
+
+// nextScheduledTime returns the rule's next occurrence after fromTime with
+// skip calendars applied, before jitter.
+func (s *Scheduler) nextScheduledTime(rule config.Rule, fromTime time.Time) (time.Time, error) {
+	cursor := fromTime
+	for i := 0; i < maxCalendarSearch; i++ {
+		next, err := s.GetNextRunTime(rule.ScheduleExpr(), cursor)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if !rule.IsBlackout(next) {
+			return next, nil
+		}
+
+		var shifted time.Time
+		switch rule.CalendarPolicy {
+		case config.CalendarPolicyNextBusinessDay:
+			shifted = shiftBusinessDay(rule, next, 1)
+		case config.CalendarPolicyPreviousBusinessDay:
+			shifted = shiftBusinessDay(rule, next, -1)
+		}
+
+		// A shifted occurrence that lands on or before fromTime has either
+		// already fired or merged with another occurrence, so keep looking.
+		if !shifted.IsZero() && shifted.After(fromTime) {
+			return shifted, nil
+		}
+
+		cursor = next
+	}
+
+	return time.Time{}, fmt.Errorf("no run time outside skip calendars found for rule %s", rule.Name)
+}
+
+// shiftBusinessDay moves t by whole days in direction dir until it reaches a
+// weekday that isn't on one of the rule's skip calendars. The time of day is
+// kept.
+func shiftBusinessDay(rule config.Rule, t time.Time, dir int) time.Time {
+	for i := 1; i <= maxCalendarSearch; i++ {
+		d := fromWallClock(wallClock(t).AddDate(0, 0, i*dir), t.Location())
+		if d.Weekday() == time.Saturday || d.Weekday() == time.Sunday {
+			continue
+		}
+		if !rule.IsBlackout(d) {
+			return d
+		}
+	}
+	return time.Time{}
 }
 
 func (s *Scheduler) IsDue(cronExpr string, lastRun, nextRun time.Time, now time.Time) bool {
@@ -58,75 +204,374 @@ func (s *Scheduler) GetDueRules(conf *config.Config, st *state.State, now time.T
 	var dueRules []config.Rule
 
 	for _, rule := range conf.Rules {
-		ruleState, exists := st.Rules[rule.Name]
+		key := rule.StateKey()
+		ruleState, exists := st.Rules[key]
+
+		active := rule.ActiveAt(now)
 
 		// Check if rule has no state - create initial state
 		if !exists {
 			s.lgr.Info("rule has no state, calculating initial next run time", "rule", rule.Name)
 
-			nextRun, err := s.GetNextRunTime(rule.Cron, now)
+			// Create initial state for new rule
+			ruleState = state.RuleState{
+				Name:         rule.Name,
+				CronExpr:     rule.ScheduleExpr(),
+				ScheduleHash: rule.ScheduleFingerprint(),
+				LastRunTime:  time.Time{}, // Never run before
+				Inactive:     !active,
+			}
+			err := s.setNextRun(rule, &ruleState, now)
 			if err != nil {
 				s.lgr.Error("failed to calculate initial next run time for new rule",
-					"rule", rule.Name, "cron", rule.Cron, "err", err)
+					"rule", rule.Name, "schedule", rule.ScheduleExpr(), "err", err)
 				continue
 			}
+			st.Rules[key] = ruleState
 
-			// Create initial state for new rule
-			st.Rules[rule.Name] = state.RuleState{
-				Name:        rule.Name,
-				CronExpr:    rule.Cron,
-				LastRunTime: time.Time{}, // Never run before
-				NextRunTime: nextRun,
+			continue
+		}
+
+		if !active {
+			if !ruleState.Inactive {
+				s.lgr.Info("rule is disabled or outside its active dates, skipping", "rule", rule.Name)
+				ruleState.Inactive = true
+				st.Rules[key] = ruleState
 			}
+			continue
+		}
+
+		if ruleState.Inactive {
+			s.lgr.Info("rule became active, recalculating next run time", "rule", rule.Name)
+
+			// Start from now so occurrences missed while inactive don't fire
+			err := s.setNextRun(rule, &ruleState, now)
+			if err != nil {
+				s.lgr.Error("failed to calculate next run time for reactivated rule",
+					"rule", rule.Name, "schedule", rule.ScheduleExpr(), "err", err)
+				continue
+			}
+
+			ruleState.CronExpr = rule.ScheduleExpr()
+			ruleState.ScheduleHash = rule.ScheduleFingerprint()
+			ruleState.Inactive = false
+			st.Rules[key] = ruleState
 
 			continue
 		}
 
-		if ruleState.CronExpr != rule.Cron {
-			s.lgr.Info("cron expression changed, recalculating next run time",
+		if ruleState.ScheduleHash == "" {
+			// State from before fingerprints were stored was calculated
+			// with the current calendars as far as we know
+			ruleState.ScheduleHash = rule.ScheduleFingerprint()
+			st.Rules[key] = ruleState
+		}
+
+		if ruleState.CronExpr != rule.ScheduleExpr() || ruleState.ScheduleHash != rule.ScheduleFingerprint() {
+			s.lgr.Info("schedule changed, recalculating next run time",
 				"rule", rule.Name,
-				"old_cron", ruleState.CronExpr,
-				"new_cron", rule.Cron)
+				"old_schedule", ruleState.CronExpr,
+				"new_schedule", rule.ScheduleExpr())
 
-			// Recalculate next run time based on new cron expression
-			nextRun, err := s.GetNextRunTime(rule.Cron, now)
+			// Recalculate next run time based on new schedule
+			err := s.setNextRun(rule, &ruleState, now)
 			if err != nil {
-				s.lgr.Error("failed to calculate next run time for updated cron",
-					"rule", rule.Name, "cron", rule.Cron, "err", err)
+				s.lgr.Error("failed to calculate next run time for updated schedule",
+					"rule", rule.Name, "schedule", rule.ScheduleExpr(), "err", err)
 				continue
 			}
 
-			// Update state with new cron and next run time
-			st.Rules[rule.Name] = state.RuleState{
-				Name:        rule.Name,
-				CronExpr:    rule.Cron,
-				LastRunTime: ruleState.LastRunTime, // Keep existing last run time
-				NextRunTime: nextRun,
+			// Update state with new cron and next run time, keeping the last
+			// run time and any skip or snooze. The occurrence count is kept
+			// too, so editing the schedule doesn't reset max_occurrences.
+			ruleState.Name = rule.Name
+			ruleState.CronExpr = rule.ScheduleExpr()
+			ruleState.ScheduleHash = rule.ScheduleFingerprint()
+			st.Rules[key] = ruleState
+
+			continue
+		}
+
+		if ruleState.Finished {
+			continue
+		}
+
+		if rule.MaxOccurrences > 0 && ruleState.Occurrences >= rule.MaxOccurrences {
+			s.lgr.Debug("rule reached max occurrences, skipping",
+				"rule", rule.Name, "max_occurrences", rule.MaxOccurrences)
+			continue
+		}
+
+		if !s.IsDue(rule.ScheduleExpr(), ruleState.LastRunTime, ruleState.NextRunTime, now) {
+			continue
+		}
+
+		if rule.IsBlackout(ruleState.NextRunTime) {
+			// The date became a skip calendar date after the run time was
+			// calculated, so apply the calendar policy again
+			s.lgr.Info("next run is on a skip calendar date, recalculating",
+				"rule", rule.Name, "old_next_run", ruleState.NextRunTime)
+
+			err := s.setNextRun(rule, &ruleState, occurrenceFrom(rule, ruleState))
+			if err != nil {
+				s.lgr.Error("failed to calculate next run time for skip calendar date",
+					"rule", rule.Name, "schedule", rule.ScheduleExpr(), "err", err)
+				continue
 			}
+			st.Rules[key] = ruleState
 
+			if ruleState.Finished || !s.IsDue(rule.ScheduleExpr(), ruleState.LastRunTime, ruleState.NextRunTime, now) {
+				continue
+			}
+		}
+
+		if now.Before(ruleState.SnoozedUntil) {
+			s.lgr.Debug("rule is snoozed", "rule", rule.Name, "until", ruleState.SnoozedUntil)
 			continue
 		}
 
-		if s.IsDue(rule.Cron, ruleState.LastRunTime, ruleState.NextRunTime, now) {
-			dueRules = append(dueRules, rule)
+		if ruleState.SkipRemaining > 0 {
+			err := s.setNextRun(rule, &ruleState, now)
+			if err != nil {
+				s.lgr.Error("failed to calculate next run time for skipped occurrence",
+					"rule", rule.Name, "schedule", rule.ScheduleExpr(), "err", err)
+				continue
+			}
+
+			ruleState.SkipRemaining--
+			st.Rules[key] = ruleState
+
+			s.lgr.Info("skipping occurrence", "rule", rule.Name,
+				"skips_remaining", ruleState.SkipRemaining, "next_run", ruleState.NextRunTime)
+			continue
 		}
+
+		dueRules = append(dueRules, rule)
 	}
 
 	return dueRules, nil
 }
 
-func (s *Scheduler) UpdateRuleState(st *state.State, ruleName, cronExpr string, runTime time.Time) error {
-	nextRun, err := s.GetNextRunTime(cronExpr, runTime)
+// setNextRun stores the rule's next run time after from in ruleState. A
+// schedule with no more occurrences marks the rule finished instead.
+func (s *Scheduler) setNextRun(rule config.Rule, ruleState *state.RuleState, from time.Time) error {
+	nextRun, err := s.GetRuleNextRunTime(rule, from)
+	if errors.Is(err, ErrNoMoreOccurrences) {
+		if !ruleState.Finished {
+			s.lgr.Info("rule schedule has no more occurrences", "rule", rule.Name)
+		}
+		ruleState.NextRunTime = time.Time{}
+		ruleState.Finished = true
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("calculate next run time for rule %s: %w", ruleName, err)
+		return err
+	}
+
+	ruleState.NextRunTime = nextRun
+	ruleState.Finished = false
+	return nil
+}
+
+// occurrenceFrom returns a time just before the scheduled time of the
+// occurrence due at ruleState.NextRunTime, which may have been delayed by
+// up to the rule's jitter, but not before the rule last ran.
+func occurrenceFrom(rule config.Rule, ruleState state.RuleState) time.Time {
+	from := ruleState.NextRunTime.Add(-rule.Jitter.Duration - time.Second)
+	if from.Before(ruleState.LastRunTime) {
+		from = ruleState.LastRunTime
+	}
+	return from
+}
+
+// NextWakeTime returns the earliest time anything in st becomes due: a rule's
+// next run, a repeat or a deferred delivery. Rules without state are due at
+// now. It returns the zero time if nothing is scheduled. Inactive and
+// finished rules are ignored, so callers should still wake periodically to
+// notice rules becoming active.
+func (s *Scheduler) NextWakeTime(conf *config.Config, st *state.State, now time.Time) time.Time {
+	var earliest time.Time
+	consider := func(t time.Time) {
+		if !t.IsZero() && (earliest.IsZero() || t.Before(earliest)) {
+			earliest = t
+		}
+	}
+
+	for _, rule := range conf.Rules {
+		ruleState, exists := st.Rules[rule.StateKey()]
+		if !exists {
+			consider(now)
+			continue
+		}
+
+		if !rule.ActiveAt(now) {
+			continue
+		}
+
+		if ruleState.Pending != nil {
+			consider(ruleState.Pending.NextRepeatAt)
+		}
+
+		if ruleState.Finished || (rule.MaxOccurrences > 0 && ruleState.Occurrences >= rule.MaxOccurrences) {
+			continue
+		}
+
+		next := ruleState.NextRunTime
+		if ruleState.SnoozedUntil.After(next) {
+			next = ruleState.SnoozedUntil
+		}
+		consider(next)
+	}
+
+	for _, d := range st.Deferred {
+		consider(d.SendAfter)
+	}
+
+	return earliest
+}
+
+// UpdateRuleState records that rule fired at runTime. The occurrence stays
+// pending until acknowledged if the rule repeats or notifications carry
+// acknowledge links; otherwise there is nothing to acknowledge.
+func (s *Scheduler) UpdateRuleState(conf *config.Config, st *state.State, rule config.Rule, runTime time.Time) error {
+	key := rule.StateKey()
+	ruleState := st.Rules[key]
+
+	// A jittered occurrence can run after the following occurrence's
+	// scheduled time, so count from the time it was due. Occurrences whose
+	// fire time has already passed are still skipped.
+	from := runTime
+	if !ruleState.NextRunTime.IsZero() && ruleState.NextRunTime.Before(runTime) {
+		from = ruleState.NextRunTime
+	}
+	nextRun, err := s.GetRuleNextRunTime(rule, from)
+	if err == nil && !nextRun.After(runTime) {
+		nextRun, err = s.GetRuleNextRunTime(rule, runTime)
+	}
+	finished := errors.Is(err, ErrNoMoreOccurrences)
+	if err != nil && !finished {
+		return fmt.Errorf("calculate next run time for rule %s: %w", rule.Name, err)
 	}
+	if finished {
+		s.lgr.Info("rule schedule has no more occurrences", "rule", rule.Name)
+	}
+
+	ruleState.Name = rule.Name
+	ruleState.CronExpr = rule.ScheduleExpr()
+	ruleState.ScheduleHash = rule.ScheduleFingerprint()
+	ruleState.LastRunTime = runTime
+	ruleState.NextRunTime = nextRun
+	ruleState.Finished = finished
+	ruleState.SnoozedUntil = time.Time{}
+	ruleState.Occurrences++
 
-	st.Rules[ruleName] = state.RuleState{
-		Name:        ruleName,
-		CronExpr:    cronExpr,
-		LastRunTime: runTime,
-		NextRunTime: nextRun,
+	if ruleState.Pending != nil {
+		s.lgr.Info("replacing unacknowledged occurrence",
+			"rule", rule.Name, "occurrence", ruleState.Pending.Occurrence)
+	}
+	ruleState.Pending = nil
+	if rule.RepeatEvery.Duration > 0 || awaitsAck(conf) {
+		ruleState.Pending = &state.PendingOccurrence{
+			Occurrence:  ruleState.Occurrences,
+			FirstSentAt: runTime,
+			LastSentAt:  runTime,
+		}
+		if rule.RepeatEvery.Duration > 0 {
+			ruleState.Pending.NextRepeatAt = runTime.Add(rule.RepeatEvery.Duration)
+		}
 	}
 
+	st.Rules[key] = ruleState
+
 	return nil
 }
+
+// awaitsAck reports whether the rule's occurrences wait for an
+// acknowledgement, which is when notifications carry acknowledge links.
+func awaitsAck(conf *config.Config) bool {
+	return conf.Links != nil
+}
+
+// ReconcileState matches stored rule state against the configured rules.
+// State for rules missing from the config is marked orphaned, then pruned or
+// archived once the grace period has passed. Rules that come back after being
+// orphaned get a fresh next run time so stale occurrences don't fire.
+func (s *Scheduler) ReconcileState(conf *config.Config, st *state.State, now time.Time) {
+	action, grace := conf.OrphanPolicy()
+
+	configured := make(map[string]bool)
+	for _, rule := range conf.Rules {
+		configured[rule.StateKey()] = true
+	}
+
+	for _, rule := range conf.Rules {
+		key := rule.StateKey()
+		ruleState, exists := st.Rules[key]
+
+		if !exists && rule.ID != "" && !configured[rule.Name] {
+			// The rule was given an id; carry over state stored under its name
+			if old, ok := st.Rules[rule.Name]; ok {
+				s.lgr.Info("moving rule state to rule id", "rule", rule.Name, "id", rule.ID)
+				delete(st.Rules, rule.Name)
+				st.Rules[key] = old
+				ruleState, exists = old, true
+			}
+		}
+
+		if !exists {
+			archived, ok := st.Archived[key]
+			if !ok {
+				continue
+			}
+			s.lgr.Info("restoring archived rule state", "rule", rule.Name, "orphaned_at", archived.OrphanedAt)
+			delete(st.Archived, key)
+			ruleState = archived
+		} else if ruleState.OrphanedAt.IsZero() {
+			continue
+		} else {
+			s.lgr.Info("orphaned rule returned to config", "rule", rule.Name, "orphaned_at", ruleState.OrphanedAt)
+		}
+
+		err := s.setNextRun(rule, &ruleState, now)
+		if err != nil {
+			s.lgr.Error("failed to calculate next run time for returning rule",
+				"rule", rule.Name, "schedule", rule.ScheduleExpr(), "err", err)
+			continue
+		}
+
+		ruleState.Name = rule.Name
+		ruleState.CronExpr = rule.ScheduleExpr()
+		ruleState.ScheduleHash = rule.ScheduleFingerprint()
+		ruleState.OrphanedAt = time.Time{}
+		st.Rules[key] = ruleState
+	}
+
+	for key, ruleState := range st.Rules {
+		if configured[key] {
+			continue
+		}
+
+		if ruleState.OrphanedAt.IsZero() {
+			s.lgr.Warn("rule state has no matching rule in config",
+				"rule", ruleState.Name, "key", key, "grace_period", grace)
+			ruleState.OrphanedAt = now
+			st.Rules[key] = ruleState
+			continue
+		}
+
+		if now.Sub(ruleState.OrphanedAt) < grace {
+			continue
+		}
+
+		delete(st.Rules, key)
+		if action == config.OrphanActionArchive {
+			s.lgr.Info("archiving orphaned rule state", "rule", ruleState.Name, "key", key, "orphaned_at", ruleState.OrphanedAt)
+			if st.Archived == nil {
+				st.Archived = make(map[string]state.RuleState)
+			}
+			st.Archived[key] = ruleState
+		} else {
+			s.lgr.Info("pruning orphaned rule state", "rule", ruleState.Name, "key", key, "orphaned_at", ruleState.OrphanedAt)
+		}
+	}
+}
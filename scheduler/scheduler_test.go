@@ -357,8 +357,9 @@ func TestUpdateRuleState(t *testing.T) {
 
 	runTime := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
 	cronExpr := "0 9 * * *"
+	conf := &config.Config{}
 
-	err := s.UpdateRuleState(st, "test_rule", cronExpr, runTime)
+	err := s.UpdateRuleState(conf, st, config.Rule{Name: "test_rule", Cron: cronExpr}, runTime)
 	if err != nil {
 		t.Fatalf("UpdateRuleState() error = %v", err)
 	}
@@ -390,6 +391,20 @@ func TestUpdateRuleState(t *testing.T) {
 	if !ruleState.NextRunTime.Equal(expectedNext) {
 		t.Errorf("Expected next run time %v, got %v", expectedNext, ruleState.NextRunTime)
 	}
+
+	// Without repeats or links there is nothing to acknowledge
+	if ruleState.Pending != nil {
+		t.Errorf("Expected no pending occurrence, got %+v", ruleState.Pending)
+	}
+
+	conf.Links = &config.Links{BaseURL: "https://example.com/", SigningKey: "0123456789abcdef0123456789abcdef"}
+	err = s.UpdateRuleState(conf, st, config.Rule{Name: "test_rule", Cron: cronExpr}, expectedNext)
+	if err != nil {
+		t.Fatalf("UpdateRuleState() error = %v", err)
+	}
+	if p := st.Rules["test_rule"].Pending; p == nil || p.Occurrence != 2 || !p.NextRepeatAt.IsZero() {
+		t.Errorf("Expected occurrence 2 to be pending acknowledgement without repeats, got %+v", p)
+	}
 }
 
 func TestCronExpressionChange(t *testing.T) {
@@ -584,3 +599,1337 @@ func TestNewRuleBecomesDue(t *testing.T) {
 		t.Errorf("Expected test_rule to be due, got %s", dueRules[0].Name)
 	}
 }
+
+func TestReconcileState(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	now := time.Date(2024, 1, 15, 9, 30, 0, 0, time.UTC)
+	staleNext := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	conf := &config.Config{
+		Rules: []config.Rule{
+			{
+				Name: "kept_rule",
+				Cron: "0 9 * * *",
+			},
+			{
+				Name: "returning_rule",
+				Cron: "0 9 * * *",
+			},
+			{
+				ID:   "renamed",
+				Name: "renamed_rule",
+				Cron: "0 9 * * *",
+			},
+		},
+		OrphanAction: config.OrphanActionArchive,
+	}
+
+	st := &state.State{
+		Rules: map[string]state.RuleState{
+			"kept_rule": {
+				Name:        "kept_rule",
+				CronExpr:    "0 9 * * *",
+				NextRunTime: now,
+			},
+			"returning_rule": {
+				Name:        "returning_rule",
+				CronExpr:    "0 9 * * *",
+				NextRunTime: staleNext,
+				OrphanedAt:  now.Add(-24 * time.Hour),
+			},
+			"renamed_rule": {
+				Name:        "renamed_rule",
+				CronExpr:    "0 9 * * *",
+				LastRunTime: staleNext,
+				NextRunTime: now,
+			},
+			"new_orphan": {
+				Name:        "new_orphan",
+				CronExpr:    "0 9 * * *",
+				NextRunTime: now,
+			},
+			"old_orphan": {
+				Name:        "old_orphan",
+				CronExpr:    "0 9 * * *",
+				NextRunTime: staleNext,
+				OrphanedAt:  now.Add(-8 * 24 * time.Hour),
+			},
+		},
+	}
+
+	s.ReconcileState(conf, st, now)
+
+	if got := st.Rules["kept_rule"].NextRunTime; !got.Equal(now) {
+		t.Errorf("kept_rule next run time changed to %v", got)
+	}
+
+	returning := st.Rules["returning_rule"]
+	if !returning.OrphanedAt.IsZero() {
+		t.Errorf("Expected returning_rule orphaned_at to be cleared, got %v", returning.OrphanedAt)
+	}
+	expectedNext := time.Date(2024, 1, 16, 9, 0, 0, 0, time.UTC)
+	if !returning.NextRunTime.Equal(expectedNext) {
+		t.Errorf("Expected returning_rule next run time %v, got %v", expectedNext, returning.NextRunTime)
+	}
+
+	if _, exists := st.Rules["renamed_rule"]; exists {
+		t.Error("Expected renamed_rule state to move to its id")
+	}
+	if renamed := st.Rules["renamed"]; !renamed.LastRunTime.Equal(staleNext) {
+		t.Errorf("Expected renamed state to keep last run time, got %v", renamed.LastRunTime)
+	}
+
+	if orphan := st.Rules["new_orphan"]; !orphan.OrphanedAt.Equal(now) {
+		t.Errorf("Expected new_orphan to be marked orphaned at %v, got %v", now, orphan.OrphanedAt)
+	}
+
+	if _, exists := st.Rules["old_orphan"]; exists {
+		t.Error("Expected old_orphan to be removed from active state")
+	}
+	if _, exists := st.Archived["old_orphan"]; !exists {
+		t.Error("Expected old_orphan to be archived")
+	}
+
+	// Re-adding an archived rule restores its history with a fresh schedule
+	conf.Rules = append(conf.Rules, config.Rule{Name: "old_orphan", Cron: "0 9 * * *"})
+	s.ReconcileState(conf, st, now)
+
+	restored, exists := st.Rules["old_orphan"]
+	if !exists {
+		t.Fatal("Expected old_orphan state to be restored from archive")
+	}
+	if _, exists := st.Archived["old_orphan"]; exists {
+		t.Error("Expected old_orphan to be removed from archive")
+	}
+	if !restored.NextRunTime.Equal(expectedNext) {
+		t.Errorf("Expected restored next run time %v, got %v", expectedNext, restored.NextRunTime)
+	}
+}
+
+func TestInactiveRules(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	now := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
+	staleNext := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	disabled := false
+
+	conf := &config.Config{
+		Rules: []config.Rule{
+			{
+				Name:    "disabled_rule",
+				Cron:    "0 9 * * *",
+				Enabled: &disabled,
+			},
+			{
+				Name:      "not_started_rule",
+				Cron:      "0 9 * * *",
+				StartDate: "2024-02-01",
+			},
+			{
+				Name:    "ended_rule",
+				Cron:    "0 9 * * *",
+				EndDate: "2024-01-14",
+			},
+			{
+				Name:    "last_day_rule",
+				Cron:    "0 9 * * *",
+				EndDate: "2024-01-15",
+			},
+		},
+	}
+
+	st := &state.State{
+		Rules: map[string]state.RuleState{
+			"disabled_rule": {
+				Name:        "disabled_rule",
+				CronExpr:    "0 9 * * *",
+				LastRunTime: staleNext,
+				NextRunTime: now,
+			},
+			"not_started_rule": {
+				Name:        "not_started_rule",
+				CronExpr:    "0 9 * * *",
+				NextRunTime: now,
+			},
+			"ended_rule": {
+				Name:        "ended_rule",
+				CronExpr:    "0 9 * * *",
+				NextRunTime: now,
+			},
+			"last_day_rule": {
+				Name:        "last_day_rule",
+				CronExpr:    "0 9 * * *",
+				NextRunTime: now,
+			},
+		},
+	}
+
+	dueRules, err := s.GetDueRules(conf, st, now)
+	if err != nil {
+		t.Fatalf("GetDueRules() error = %v", err)
+	}
+
+	if len(dueRules) != 1 || dueRules[0].Name != "last_day_rule" {
+		t.Fatalf("Expected only last_day_rule to be due, got %v", dueRules)
+	}
+
+	for _, name := range []string{"disabled_rule", "not_started_rule", "ended_rule"} {
+		rs := st.Rules[name]
+		if !rs.Inactive {
+			t.Errorf("Expected %s to be marked inactive", name)
+		}
+		if !rs.NextRunTime.Equal(now) {
+			t.Errorf("Expected %s state to be kept, next run time changed to %v", name, rs.NextRunTime)
+		}
+	}
+
+	// Re-enable a week later: the missed occurrences must not fire
+	later := now.Add(7 * 24 * time.Hour)
+	conf.Rules[0].Enabled = nil
+
+	dueRules, err = s.GetDueRules(conf, st, later)
+	if err != nil {
+		t.Fatalf("GetDueRules() error = %v", err)
+	}
+
+	for _, rule := range dueRules {
+		if rule.Name == "disabled_rule" {
+			t.Error("Expected re-enabled rule not to fire immediately")
+		}
+	}
+
+	rs := st.Rules["disabled_rule"]
+	if rs.Inactive {
+		t.Error("Expected re-enabled rule to be active")
+	}
+	expectedNext := time.Date(2024, 1, 23, 9, 0, 0, 0, time.UTC)
+	if !rs.NextRunTime.Equal(expectedNext) {
+		t.Errorf("Expected next run time %v, got %v", expectedNext, rs.NextRunTime)
+	}
+	if !rs.LastRunTime.Equal(staleNext) {
+		t.Errorf("Expected last run time to be kept, got %v", rs.LastRunTime)
+	}
+}
+
+func TestMaxOccurrences(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	rule := config.Rule{
+		Name:           "nag",
+		Cron:           "0 9 * * *",
+		MaxOccurrences: 3,
+	}
+	conf := &config.Config{Rules: []config.Rule{rule}}
+
+	st := &state.State{
+		Rules: make(map[string]state.RuleState),
+	}
+
+	now := time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC)
+	_, err := s.GetDueRules(conf, st, now)
+	if err != nil {
+		t.Fatalf("GetDueRules() error = %v", err)
+	}
+
+	var fired int
+	for day := 0; day < 5; day++ {
+		now = time.Date(2024, 1, 15+day, 9, 0, 0, 0, time.UTC)
+		dueRules, err := s.GetDueRules(conf, st, now)
+		if err != nil {
+			t.Fatalf("GetDueRules() error = %v", err)
+		}
+
+		for _, r := range dueRules {
+			fired++
+			err = s.UpdateRuleState(conf, st, r, now)
+			if err != nil {
+				t.Fatalf("UpdateRuleState() error = %v", err)
+			}
+		}
+	}
+
+	if fired != 3 {
+		t.Errorf("Expected rule to fire 3 times, fired %d", fired)
+	}
+
+	if got := st.Rules["nag"].Occurrences; got != 3 {
+		t.Errorf("Expected 3 occurrences in state, got %d", got)
+	}
+}
+
+func TestMaxOccurrencesScheduleChange(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	rule := config.Rule{
+		Name:           "nag",
+		Cron:           "0 9 * * *",
+		MaxOccurrences: 3,
+	}
+	conf := &config.Config{Rules: []config.Rule{rule}}
+
+	st := &state.State{
+		Rules: make(map[string]state.RuleState),
+	}
+
+	var fired int
+	run := func(now time.Time) {
+		dueRules, err := s.GetDueRules(conf, st, now)
+		if err != nil {
+			t.Fatalf("GetDueRules() error = %v", err)
+		}
+		for _, r := range dueRules {
+			fired++
+			err = s.UpdateRuleState(conf, st, r, now)
+			if err != nil {
+				t.Fatalf("UpdateRuleState() error = %v", err)
+			}
+		}
+	}
+
+	run(time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC))
+	for day := 0; day < 2; day++ {
+		run(time.Date(2024, 1, 15+day, 9, 0, 0, 0, time.UTC))
+	}
+
+	// Moving the reminder to the afternoon must not start the count again
+	conf.Rules[0].Cron = "0 17 * * *"
+	run(time.Date(2024, 1, 16, 12, 0, 0, 0, time.UTC))
+	for day := 0; day < 5; day++ {
+		run(time.Date(2024, 1, 16+day, 17, 0, 0, 0, time.UTC))
+	}
+
+	if fired != 3 {
+		t.Errorf("Expected rule to fire 3 times across the schedule change, fired %d", fired)
+	}
+	if got := st.Rules["nag"].Occurrences; got != 3 {
+		t.Errorf("Expected 3 occurrences in state, got %d", got)
+	}
+}
+
+func TestGetRuleNextRunTimeCalendars(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	monday := time.Date(2024, 12, 23, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		cron     string
+		policy   string
+		fromTime time.Time
+		expected time.Time
+	}{
+		{
+			name:     "no policy skips the occurrence",
+			cron:     "0 9 * * 3",
+			fromTime: monday,
+			// Dec 25 and Jan 1 are both holidays
+			expected: time.Date(2025, 1, 8, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "skip policy",
+			cron:     "0 9 * * 3",
+			policy:   config.CalendarPolicySkip,
+			fromTime: monday,
+			expected: time.Date(2025, 1, 8, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "shift to next business day",
+			cron:     "0 9 * * 3",
+			policy:   config.CalendarPolicyNextBusinessDay,
+			fromTime: monday,
+			expected: time.Date(2024, 12, 26, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "shift to next business day skips weekend",
+			cron:     "0 9 * * 5",
+			policy:   config.CalendarPolicyNextBusinessDay,
+			fromTime: monday,
+			expected: time.Date(2024, 12, 30, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "shift to previous business day",
+			cron:     "0 9 * * 3",
+			policy:   config.CalendarPolicyPreviousBusinessDay,
+			fromTime: monday,
+			expected: time.Date(2024, 12, 24, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "shift to previous business day already passed",
+			cron:     "0 9 * * 3",
+			policy:   config.CalendarPolicyPreviousBusinessDay,
+			fromTime: time.Date(2024, 12, 24, 9, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 12, 31, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "non-holiday occurrence is unchanged",
+			cron:     "0 9 * * 1",
+			policy:   config.CalendarPolicyNextBusinessDay,
+			fromTime: monday,
+			expected: time.Date(2024, 12, 30, 9, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &config.Config{
+				Calendars: []config.Calendar{
+					{
+						Name:  "holidays",
+						Dates: []string{"2024-12-25", "2024-12-27", "2025-01-01"},
+					},
+				},
+				Rules: []config.Rule{
+					{
+						Name:           "weekly",
+						Cron:           tt.cron,
+						SkipCalendars:  []string{"holidays"},
+						CalendarPolicy: tt.policy,
+					},
+				},
+			}
+			err := conf.ResolveCalendars()
+			if err != nil {
+				t.Fatalf("ResolveCalendars() error = %v", err)
+			}
+
+			next, err := s.GetRuleNextRunTime(conf.Rules[0], tt.fromTime)
+			if err != nil {
+				t.Fatalf("GetRuleNextRunTime() error = %v", err)
+			}
+
+			if !next.Equal(tt.expected) {
+				t.Errorf("GetRuleNextRunTime() = %v, want %v", next, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCalendarChange(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	newConf := func(holidays ...string) *config.Config {
+		t.Helper()
+		conf := &config.Config{
+			Calendars: []config.Calendar{
+				{Name: "holidays", Dates: append([]string{"2024-01-01"}, holidays...)},
+			},
+			Rules: []config.Rule{
+				{
+					Name:           "weekly",
+					Cron:           "0 9 * * 2",
+					SkipCalendars:  []string{"holidays"},
+					CalendarPolicy: config.CalendarPolicyNextBusinessDay,
+				},
+			},
+		}
+		err := conf.ResolveCalendars()
+		if err != nil {
+			t.Fatalf("ResolveCalendars() error = %v", err)
+		}
+		return conf
+	}
+
+	tuesday := time.Date(2024, 1, 9, 9, 0, 0, 0, time.UTC)
+	nextTuesday := time.Date(2024, 1, 16, 9, 0, 0, 0, time.UTC)
+	wednesday := time.Date(2024, 1, 17, 9, 0, 0, 0, time.UTC)
+
+	t.Run("holiday added after the next run was calculated", func(t *testing.T) {
+		st := state.New()
+		conf := newConf()
+
+		_, err := s.GetDueRules(conf, st, tuesday.Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		due, err := s.GetDueRules(conf, st, tuesday)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) != 1 {
+			t.Fatalf("Expected the rule to be due on %v, got %d due", tuesday, len(due))
+		}
+		err = s.UpdateRuleState(conf, st, due[0], tuesday)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := st.Rules["weekly"].NextRunTime; !got.Equal(nextTuesday) {
+			t.Fatalf("Expected next run %v, got %v", nextTuesday, got)
+		}
+
+		conf = newConf("2024-01-16")
+		due, err = s.GetDueRules(conf, st, tuesday.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) != 0 {
+			t.Errorf("Expected nothing due after the calendar change, got %d", len(due))
+		}
+		if got := st.Rules["weekly"].NextRunTime; !got.Equal(wednesday) {
+			t.Errorf("Expected the calendar change to move the next run to %v, got %v", wednesday, got)
+		}
+
+		due, err = s.GetDueRules(conf, st, nextTuesday)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) != 0 {
+			t.Errorf("Expected the rule not to fire on the holiday, got %d due", len(due))
+		}
+	})
+
+	t.Run("stored next run on a holiday", func(t *testing.T) {
+		conf := newConf("2024-01-16")
+		// State stored before schedule fingerprints, so the calendar change
+		// can't be detected
+		st := state.New()
+		st.Rules["weekly"] = state.RuleState{
+			Name:        "weekly",
+			CronExpr:    conf.Rules[0].ScheduleExpr(),
+			LastRunTime: tuesday,
+			NextRunTime: nextTuesday,
+		}
+
+		due, err := s.GetDueRules(conf, st, nextTuesday)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) != 0 {
+			t.Errorf("Expected the rule not to fire on the holiday, got %d due", len(due))
+		}
+		if got := st.Rules["weekly"].NextRunTime; !got.Equal(wednesday) {
+			t.Errorf("Expected the calendar policy to move the next run to %v, got %v", wednesday, got)
+		}
+
+		due, err = s.GetDueRules(conf, st, wednesday)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) != 1 {
+			t.Errorf("Expected the rule to fire on the next business day, got %d due", len(due))
+		}
+	})
+}
+
+func TestGetNextRunTimeRRule(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTime := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC) // Monday
+
+	tests := []struct {
+		name     string
+		expr     string
+		fromTime time.Time
+		wantErr  bool
+		expected time.Time
+	}{
+		{
+			name:     "every 2 weeks on Tuesday",
+			expr:     "DTSTART:20240102T090000Z\nRRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=TU",
+			fromTime: testTime,
+			// Jan 2 is the first Tuesday, so the series is Jan 2, 16, 30...
+			expected: time.Date(2024, 1, 16, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "every 2 weeks on Tuesday skips off week",
+			expr:     "DTSTART:20240102T090000Z\nRRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=TU",
+			fromTime: time.Date(2024, 1, 16, 9, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 1, 30, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "last weekday of the month",
+			expr:     "DTSTART:20240101T090000Z\nRRULE:FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1",
+			fromTime: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			// March 31 2024 is a Sunday
+			expected: time.Date(2024, 3, 29, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "third Thursday of the month",
+			expr:     "DTSTART:20240101T170000Z\nRRULE:FREQ=MONTHLY;BYDAY=+3TH",
+			fromTime: testTime,
+			expected: time.Date(2024, 1, 18, 17, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "indented lines from a multiline TOML string",
+			expr:     "\n  DTSTART:20240101T170000Z\n  RRULE:FREQ=MONTHLY;BYDAY=+3TH\n",
+			fromTime: time.Date(2024, 1, 18, 17, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 2, 15, 17, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "DTSTART with TZID",
+			expr:     "DTSTART;TZID=America/New_York:20240101T090000\nRRULE:FREQ=DAILY",
+			fromTime: testTime, // 05:30 in New York
+			expected: time.Date(2024, 1, 15, 9, 0, 0, 0, newYork),
+		},
+		{
+			name:     "floating DTSTART uses location of fromTime",
+			expr:     "DTSTART:20240101T090000\nRRULE:FREQ=DAILY",
+			fromTime: testTime.In(newYork),
+			expected: time.Date(2024, 1, 15, 9, 0, 0, 0, newYork),
+		},
+		{
+			name:     "exdate is skipped",
+			expr:     "DTSTART:20240101T090000Z\nRRULE:FREQ=DAILY\nEXDATE:20240116T090000Z",
+			fromTime: testTime,
+			expected: time.Date(2024, 1, 17, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "count exhausted",
+			expr:     "DTSTART:20240101T090000Z\nRRULE:FREQ=DAILY;COUNT=3",
+			fromTime: testTime,
+			wantErr:  true,
+		},
+		{
+			name:     "missing DTSTART",
+			expr:     "RRULE:FREQ=DAILY",
+			fromTime: testTime,
+			wantErr:  true,
+		},
+		{
+			name:     "invalid rrule",
+			expr:     "DTSTART:20240101T090000Z\nRRULE:FREQ=SOMETIMES",
+			fromTime: testTime,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nextTime, err := s.GetNextRunTime(tt.expr, tt.fromTime)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetNextRunTime() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !tt.wantErr && !nextTime.Equal(tt.expected) {
+				t.Errorf("GetNextRunTime() = %v, want %v", nextTime, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidateRuleRRule(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	valid := config.Rule{
+		Name:  "biweekly",
+		RRule: "DTSTART:20240102T090000Z\nRRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=TU",
+	}
+	if err := s.ValidateRule(&valid); err != nil {
+		t.Errorf("ValidateRule() error = %v", err)
+	}
+
+	invalid := config.Rule{
+		Name:  "bad",
+		RRule: "DTSTART:20240102T090000Z\nRRULE:BYDAY=TU;FREQ=FORTNIGHTLY",
+	}
+	if err := s.ValidateRule(&invalid); err == nil {
+		t.Error("Expected error for invalid rrule")
+	}
+}
+
+func TestRRuleRuleScheduling(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	conf := &config.Config{
+		Rules: []config.Rule{
+			{
+				Name:  "biweekly",
+				RRule: "DTSTART:20240102T090000Z\nRRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=TU",
+			},
+		},
+	}
+	st := &state.State{
+		Rules: make(map[string]state.RuleState),
+	}
+
+	_, err := s.GetDueRules(conf, st, time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("GetDueRules() error = %v", err)
+	}
+
+	rs := st.Rules["biweekly"]
+	if rs.CronExpr != conf.Rules[0].ScheduleExpr() {
+		t.Errorf("Expected rrule to be stored as schedule, got %q", rs.CronExpr)
+	}
+
+	expected := time.Date(2024, 1, 16, 9, 0, 0, 0, time.UTC)
+	if !rs.NextRunTime.Equal(expected) {
+		t.Errorf("Expected next run time %v, got %v", expected, rs.NextRunTime)
+	}
+}
+
+func TestRRuleFinishes(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	conf := &config.Config{
+		Rules: []config.Rule{
+			{
+				Name:  "twice",
+				RRule: "DTSTART:20240101T090000Z\nRRULE:FREQ=DAILY;COUNT=2",
+			},
+		},
+	}
+	st := state.New()
+
+	var fires []time.Time
+	start := time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 4)
+	for now := start; now.Before(end); now = now.Add(time.Minute) {
+		due, err := s.GetDueRules(conf, st, now)
+		if err != nil {
+			t.Fatalf("GetDueRules() error = %v", err)
+		}
+		for _, rule := range due {
+			fires = append(fires, now)
+			err = s.UpdateRuleState(conf, st, rule, now)
+			if err != nil {
+				t.Fatalf("UpdateRuleState() error = %v", err)
+			}
+		}
+	}
+
+	if len(fires) != 2 {
+		t.Fatalf("Expected 2 fires, got %d: %v", len(fires), fires)
+	}
+
+	rs := st.Rules["twice"]
+	if !rs.Finished || !rs.NextRunTime.IsZero() {
+		t.Errorf("Expected the rule to be finished with no next run, got %+v", rs)
+	}
+	if rs.Occurrences != 2 || !rs.LastRunTime.Equal(fires[1]) {
+		t.Errorf("Expected 2 occurrences last run at %v, got %d at %v", fires[1], rs.Occurrences, rs.LastRunTime)
+	}
+
+	if wake := s.NextWakeTime(conf, st, end); !wake.IsZero() {
+		t.Errorf("Expected no wake time for a finished rule, got %v", wake)
+	}
+
+	// Extending the rule brings it back
+	conf.Rules[0].RRule = "DTSTART:20240101T090000Z\nRRULE:FREQ=DAILY;COUNT=5"
+	_, err := s.GetDueRules(conf, st, end)
+	if err != nil {
+		t.Fatalf("GetDueRules() error = %v", err)
+	}
+	rs = st.Rules["twice"]
+	if want := time.Date(2024, 1, 4, 9, 0, 0, 0, time.UTC); rs.Finished || !rs.NextRunTime.Equal(want) {
+		t.Errorf("Expected the extended rule to run next at %v, got %+v", want, rs)
+	}
+}
+
+func TestGetNextRunTimeInterval(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	anchor := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		every    time.Duration
+		fromTime time.Time
+		expected time.Time
+	}{
+		{
+			name:     "before anchor",
+			every:    10 * 24 * time.Hour,
+			fromTime: time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC),
+			expected: anchor,
+		},
+		{
+			name:     "at anchor",
+			every:    10 * 24 * time.Hour,
+			fromTime: anchor,
+			expected: time.Date(2026, 10, 11, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "between occurrences",
+			every:    10 * 24 * time.Hour,
+			fromTime: time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2026, 10, 21, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "every 90 minutes",
+			every:    90 * time.Minute,
+			fromTime: time.Date(2026, 10, 1, 13, 0, 0, 0, time.UTC),
+			expected: time.Date(2026, 10, 1, 13, 30, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := config.Rule{
+				Name:   "interval",
+				Every:  config.Duration{Duration: tt.every},
+				Anchor: anchor,
+			}
+
+			nextTime, err := s.GetNextRunTime(rule.ScheduleExpr(), tt.fromTime)
+			if err != nil {
+				t.Fatalf("GetNextRunTime() error = %v", err)
+			}
+
+			if !nextTime.Equal(tt.expected) {
+				t.Errorf("GetNextRunTime() = %v, want %v", nextTime, tt.expected)
+			}
+		})
+	}
+
+	_, err := s.GetNextRunTime("@every 1h", anchor)
+	if err == nil {
+		t.Error("Expected error for interval without anchor")
+	}
+}
+
+func TestIntervalDoesNotDrift(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	rule := config.Rule{
+		Name:   "every_90m",
+		Every:  config.Duration{Duration: 90 * time.Minute},
+		Anchor: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	st := &state.State{
+		Rules: make(map[string]state.RuleState),
+	}
+
+	conf := &config.Config{Rules: []config.Rule{rule}}
+
+	// The 01:30 occurrence runs 7 minutes late
+	lateRun := time.Date(2026, 10, 1, 1, 37, 0, 0, time.UTC)
+	err := s.UpdateRuleState(conf, st, rule, lateRun)
+	if err != nil {
+		t.Fatalf("UpdateRuleState() error = %v", err)
+	}
+
+	expected := time.Date(2026, 10, 1, 3, 0, 0, 0, time.UTC)
+	if next := st.Rules["every_90m"].NextRunTime; !next.Equal(expected) {
+		t.Errorf("Expected next run time %v, got %v", expected, next)
+	}
+}
+
+func TestGetNextRunTimeDST(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	load := func(name string) *time.Location {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return loc
+	}
+	newYork := load("America/New_York")
+	london := load("Europe/London")
+	sydney := load("Australia/Sydney")
+
+	tests := []struct {
+		name     string
+		cronExpr string
+		fromTime time.Time
+		expected time.Time // in UTC to make the instant unambiguous
+	}{
+		{
+			name:     "new york spring forward nonexistent time is shifted",
+			cronExpr: "30 2 * * *",
+			fromTime: time.Date(2024, 3, 9, 12, 0, 0, 0, newYork),
+			expected: time.Date(2024, 3, 10, 7, 30, 0, 0, time.UTC), // 03:30 EDT
+		},
+		{
+			name:     "new york day after spring forward",
+			cronExpr: "30 2 * * *",
+			fromTime: time.Date(2024, 3, 10, 7, 30, 0, 0, time.UTC).In(newYork),
+			expected: time.Date(2024, 3, 11, 6, 30, 0, 0, time.UTC), // 02:30 EDT
+		},
+		{
+			name:     "new york fall back ambiguous time fires on first instance",
+			cronExpr: "30 1 * * *",
+			fromTime: time.Date(2024, 11, 2, 12, 0, 0, 0, newYork),
+			expected: time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC), // 01:30 EDT
+		},
+		{
+			name:     "new york fall back does not fire on second instance",
+			cronExpr: "30 1 * * *",
+			fromTime: time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC).In(newYork),
+			expected: time.Date(2024, 11, 4, 6, 30, 0, 0, time.UTC), // 01:30 EST next day
+		},
+		{
+			name:     "new york hourly through fall back fires once per wall hour",
+			cronExpr: "0 * * * *",
+			fromTime: time.Date(2024, 11, 3, 5, 0, 0, 0, time.UTC).In(newYork), // 01:00 EDT
+			expected: time.Date(2024, 11, 3, 7, 0, 0, 0, time.UTC),             // 02:00 EST
+		},
+		{
+			name:     "new york from second instance of ambiguous hour",
+			cronExpr: "30 1 * * *",
+			fromTime: time.Date(2024, 11, 3, 6, 10, 0, 0, time.UTC).In(newYork), // 01:10 EST
+			expected: time.Date(2024, 11, 4, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "new york every five minutes from second instance of ambiguous hour",
+			cronExpr: "*/5 * * * *",
+			fromTime: time.Date(2024, 11, 3, 6, 5, 0, 0, time.UTC).In(newYork), // 01:05 EST
+			expected: time.Date(2024, 11, 3, 7, 0, 0, 0, time.UTC),             // 02:00 EST
+		},
+		{
+			name:     "new york every minute from second instance of ambiguous hour",
+			cronExpr: "* * * * *",
+			fromTime: time.Date(2024, 11, 3, 6, 5, 0, 0, time.UTC).In(newYork), // 01:05 EST
+			expected: time.Date(2024, 11, 3, 7, 0, 0, 0, time.UTC),             // 02:00 EST
+		},
+		{
+			name:     "new york every minute from last minute of second instance",
+			cronExpr: "* * * * *",
+			fromTime: time.Date(2024, 11, 3, 6, 59, 30, 0, time.UTC).In(newYork), // 01:59:30 EST
+			expected: time.Date(2024, 11, 3, 7, 0, 0, 0, time.UTC),               // 02:00 EST
+		},
+		{
+			name:     "new york every five minutes through first instance of ambiguous hour",
+			cronExpr: "*/5 * * * *",
+			fromTime: time.Date(2024, 11, 3, 5, 55, 0, 0, time.UTC).In(newYork), // 01:55 EDT
+			expected: time.Date(2024, 11, 3, 7, 0, 0, 0, time.UTC),              // 02:00 EST
+		},
+		{
+			name:     "new york every five minutes from before fall back",
+			cronExpr: "*/5 * * * *",
+			fromTime: time.Date(2024, 11, 3, 4, 58, 0, 0, time.UTC).In(newYork), // 00:58 EDT
+			expected: time.Date(2024, 11, 3, 5, 0, 0, 0, time.UTC),              // 01:00 EDT
+		},
+		{
+			name:     "london spring forward nonexistent time is shifted",
+			cronExpr: "30 1 * * *",
+			fromTime: time.Date(2024, 3, 30, 12, 0, 0, 0, london),
+			expected: time.Date(2024, 3, 31, 1, 30, 0, 0, time.UTC), // 02:30 BST
+		},
+		{
+			name:     "london fall back ambiguous time fires on first instance",
+			cronExpr: "30 1 * * *",
+			fromTime: time.Date(2024, 10, 26, 12, 0, 0, 0, london),
+			expected: time.Date(2024, 10, 27, 0, 30, 0, 0, time.UTC), // 01:30 BST
+		},
+		{
+			name:     "sydney spring forward nonexistent time is shifted",
+			cronExpr: "30 2 * * *",
+			fromTime: time.Date(2024, 10, 5, 12, 0, 0, 0, sydney),
+			expected: time.Date(2024, 10, 5, 16, 30, 0, 0, time.UTC), // 03:30 AEDT
+		},
+		{
+			name:     "sydney fall back ambiguous time fires on first instance",
+			cronExpr: "30 2 * * *",
+			fromTime: time.Date(2024, 4, 6, 12, 0, 0, 0, sydney),
+			expected: time.Date(2024, 4, 6, 15, 30, 0, 0, time.UTC), // 02:30 AEDT
+		},
+		{
+			name:     "sydney fall back does not fire on second instance",
+			cronExpr: "30 2 * * *",
+			fromTime: time.Date(2024, 4, 6, 15, 30, 0, 0, time.UTC).In(sydney),
+			expected: time.Date(2024, 4, 7, 16, 30, 0, 0, time.UTC), // 02:30 AEST next day
+		},
+		{
+			name:     "utc is unaffected",
+			cronExpr: "30 2 * * *",
+			fromTime: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 3, 10, 2, 30, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nextTime, err := s.GetNextRunTime(tt.cronExpr, tt.fromTime)
+			if err != nil {
+				t.Fatalf("GetNextRunTime() error = %v", err)
+			}
+
+			if !nextTime.Equal(tt.expected) {
+				t.Errorf("GetNextRunTime() = %v, want %v", nextTime, tt.expected.In(tt.fromTime.Location()))
+			}
+
+			if nextTime.Location() != tt.fromTime.Location() {
+				t.Errorf("GetNextRunTime() location = %v, want %v", nextTime.Location(), tt.fromTime.Location())
+			}
+		})
+	}
+}
+
+func TestNoDoubleFireOnFallBack(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.Config{
+		Rules: []config.Rule{
+			{
+				Name: "early",
+				Cron: "30 1 * * *",
+			},
+		},
+	}
+	st := &state.State{
+		Rules: make(map[string]state.RuleState),
+	}
+
+	// Tick every minute across the fall back night like the lambda would
+	start := time.Date(2024, 11, 2, 23, 0, 0, 0, newYork)
+	end := time.Date(2024, 11, 3, 4, 0, 0, 0, newYork)
+
+	var fires []time.Time
+	for now := start; now.Before(end); now = now.Add(time.Minute) {
+		now := now.In(newYork)
+		dueRules, err := s.GetDueRules(conf, st, now)
+		if err != nil {
+			t.Fatalf("GetDueRules() error = %v", err)
+		}
+		for _, rule := range dueRules {
+			fires = append(fires, now)
+			err = s.UpdateRuleState(conf, st, rule, now)
+			if err != nil {
+				t.Fatalf("UpdateRuleState() error = %v", err)
+			}
+		}
+	}
+
+	if len(fires) != 1 {
+		t.Fatalf("Expected 1 fire across fall back, got %d: %v", len(fires), fires)
+	}
+
+	expected := time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC)
+	if !fires[0].Equal(expected) {
+		t.Errorf("Expected fire at %v, got %v", expected.In(newYork), fires[0])
+	}
+}
+
+func TestSubHourlyThroughFallBack(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.Config{
+		Rules: []config.Rule{
+			{
+				Name: "frequent",
+				Cron: "*/5 * * * *",
+			},
+		},
+	}
+	st := &state.State{
+		Rules: make(map[string]state.RuleState),
+	}
+
+	// 00:00 EDT to 03:00 EST is four hours, but the second copy of
+	// 01:00-02:00 doesn't fire again, leaving three hours of fires. Start a
+	// minute early so the rule has state by 00:00.
+	start := time.Date(2024, 11, 3, 3, 59, 0, 0, time.UTC)
+	end := time.Date(2024, 11, 3, 8, 0, 0, 0, time.UTC)
+
+	var fires int
+	for now := start; now.Before(end); now = now.Add(time.Minute) {
+		now := now.In(newYork)
+		dueRules, err := s.GetDueRules(conf, st, now)
+		if err != nil {
+			t.Fatalf("GetDueRules() error = %v", err)
+		}
+		for _, rule := range dueRules {
+			fires++
+			err = s.UpdateRuleState(conf, st, rule, now)
+			if err != nil {
+				t.Fatalf("UpdateRuleState() error = %v", err)
+			}
+		}
+	}
+
+	if fires != 36 {
+		t.Errorf("Expected 36 fires across fall back, got %d", fires)
+	}
+
+	expectedNext := time.Date(2024, 11, 3, 8, 0, 0, 0, time.UTC) // 03:00 EST
+	if got := st.Rules["frequent"].NextRunTime; !got.Equal(expectedNext) {
+		t.Errorf("Expected next run time %v, got %v", expectedNext.In(newYork), got)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	fromTime := time.Date(2024, 1, 14, 12, 0, 0, 0, time.UTC)
+	scheduled := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
+	jitter := 10 * time.Minute
+
+	offsets := make(map[time.Duration]bool)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		rule := config.Rule{
+			Name:   name,
+			Cron:   "0 9 * * 1",
+			Jitter: config.Duration{Duration: jitter},
+		}
+
+		next, err := s.GetRuleNextRunTime(rule, fromTime)
+		if err != nil {
+			t.Fatalf("GetRuleNextRunTime() error = %v", err)
+		}
+
+		offset := next.Sub(scheduled)
+		if offset < 0 || offset >= jitter {
+			t.Errorf("Rule %s offset %v outside [0, %v)", name, offset, jitter)
+		}
+		offsets[offset] = true
+
+		again, err := s.GetRuleNextRunTime(rule, fromTime.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("GetRuleNextRunTime() error = %v", err)
+		}
+		if !again.Equal(next) {
+			t.Errorf("Rule %s fire time not stable: %v then %v", name, next, again)
+		}
+
+		// The following week gets its own offset but stays in range
+		following, err := s.GetRuleNextRunTime(rule, next)
+		if err != nil {
+			t.Fatalf("GetRuleNextRunTime() error = %v", err)
+		}
+		if d := following.Sub(scheduled.AddDate(0, 0, 7)); d < 0 || d >= jitter {
+			t.Errorf("Rule %s following offset %v outside [0, %v)", name, d, jitter)
+		}
+	}
+
+	if len(offsets) < 2 {
+		t.Errorf("Expected rules sharing a schedule to be spread out, got offsets %v", offsets)
+	}
+
+	st := &state.State{
+		Rules: make(map[string]state.RuleState),
+	}
+	rule := config.Rule{
+		Name:   "jittered",
+		Cron:   "0 9 * * 1",
+		Jitter: config.Duration{Duration: jitter},
+	}
+	_, err := s.GetDueRules(&config.Config{Rules: []config.Rule{rule}}, st, fromTime)
+	if err != nil {
+		t.Fatalf("GetDueRules() error = %v", err)
+	}
+
+	expected, _ := s.GetRuleNextRunTime(rule, fromTime)
+	if next := st.Rules["jittered"].NextRunTime; !next.Equal(expected) {
+		t.Errorf("Expected jittered fire time %v in state, got %v", expected, next)
+	}
+}
+
+func TestJitterChange(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	conf := &config.Config{
+		Rules: []config.Rule{
+			{Name: "standup", Cron: "0 9 * * *"},
+		},
+	}
+	st := state.New()
+
+	ran := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
+	_, err := s.GetDueRules(conf, st, ran.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.UpdateRuleState(conf, st, conf.Rules[0], ran)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unjittered := time.Date(2024, 1, 16, 9, 0, 0, 0, time.UTC)
+	if got := st.Rules["standup"].NextRunTime; !got.Equal(unjittered) {
+		t.Fatalf("Expected next run %v, got %v", unjittered, got)
+	}
+
+	conf.Rules[0].Jitter = config.Duration{Duration: time.Hour}
+	now := ran.Add(time.Hour)
+	_, err = s.GetDueRules(conf, st, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := s.GetRuleNextRunTime(conf.Rules[0], now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want.Equal(unjittered) {
+		t.Fatalf("Expected the test rule's jitter to be non-zero")
+	}
+	if got := st.Rules["standup"].NextRunTime; !got.Equal(want) {
+		t.Errorf("Expected adding jitter to move the next run to %v, got %v", want, got)
+	}
+}
+
+func TestJitterCappedAtPeriod(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	start := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 7)
+
+	for _, rule := range []config.Rule{
+		{Name: "cron", Cron: "*/5 * * * *", Jitter: config.Duration{Duration: time.Hour}},
+		{Name: "weekdays", Cron: "0 9 * * 1-5", Jitter: config.Duration{Duration: 72 * time.Hour}},
+		{
+			Name:   "every",
+			Every:  config.Duration{Duration: 10 * time.Minute},
+			Anchor: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			Jitter: config.Duration{Duration: 30 * time.Minute},
+		},
+	} {
+		var scheduled []time.Time
+		for next := start; ; {
+			next, _ = s.nextScheduledTime(rule, next)
+			if !next.Before(end) {
+				break
+			}
+			scheduled = append(scheduled, next)
+		}
+
+		st := &state.State{
+			Rules: make(map[string]state.RuleState),
+		}
+		conf := &config.Config{Rules: []config.Rule{rule}}
+
+		// Wake at each due time like the local daemon does
+		var fires []time.Time
+		for now := start; now.Before(end); now = s.NextWakeTime(conf, st, now) {
+			dueRules, err := s.GetDueRules(conf, st, now)
+			if err != nil {
+				t.Fatalf("GetDueRules() error = %v", err)
+			}
+			for _, r := range dueRules {
+				fires = append(fires, now)
+				err = s.UpdateRuleState(conf, st, r, now)
+				if err != nil {
+					t.Fatalf("UpdateRuleState() error = %v", err)
+				}
+			}
+		}
+
+		if len(fires) != len(scheduled) {
+			t.Errorf("Rule %s: expected %d fires, got %d", rule.Name, len(scheduled), len(fires))
+			continue
+		}
+		for i, fire := range fires {
+			if fire.Before(scheduled[i]) || (i+1 < len(scheduled) && !fire.Before(scheduled[i+1])) {
+				t.Errorf("Rule %s: fire %d at %v outside its period starting %v", rule.Name, i, fire, scheduled[i])
+				break
+			}
+		}
+	}
+}
+
+func TestJitterLateRun(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	rule := config.Rule{
+		Name:   "every",
+		Every:  config.Duration{Duration: 10 * time.Minute},
+		Anchor: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Jitter: config.Duration{Duration: 10 * time.Minute},
+	}
+
+	// The 09:00 occurrence was jittered to 09:09:30 and the run happened on
+	// the next minute tick, after the 09:10 occurrence was scheduled
+	due := time.Date(2024, 1, 15, 9, 9, 30, 0, time.UTC)
+	runTime := time.Date(2024, 1, 15, 9, 10, 15, 0, time.UTC)
+
+	expected, err := s.GetRuleNextRunTime(rule, due)
+	if err != nil {
+		t.Fatalf("GetRuleNextRunTime() error = %v", err)
+	}
+	if !expected.After(runTime) {
+		t.Fatalf("Expected the 09:10 occurrence to be jittered past %v, got %v", runTime, expected)
+	}
+
+	conf := &config.Config{Rules: []config.Rule{rule}}
+	st := &state.State{
+		Rules: map[string]state.RuleState{
+			"every": {Name: "every", CronExpr: rule.ScheduleExpr(), NextRunTime: due},
+		},
+	}
+	err = s.UpdateRuleState(conf, st, rule, runTime)
+	if err != nil {
+		t.Fatalf("UpdateRuleState() error = %v", err)
+	}
+
+	if next := st.Rules["every"].NextRunTime; !next.Equal(expected) {
+		t.Errorf("Expected the 09:10 occurrence at %v not to be skipped, got %v", expected, next)
+	}
+}
+
+func TestNextWakeTime(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	s := New(lgr)
+
+	now := time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC)
+	disabled := false
+
+	conf := &config.Config{
+		Rules: []config.Rule{
+			{Name: "daily", Cron: "0 9 * * *"},
+			{Name: "snoozed", Cron: "0 8 * * *"},
+			{Name: "repeating", Cron: "0 12 * * *"},
+			{Name: "disabled", Cron: "* * * * *", Enabled: &disabled},
+		},
+	}
+	st := &state.State{
+		Rules: map[string]state.RuleState{
+			"daily": {NextRunTime: time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)},
+			"snoozed": {
+				NextRunTime:  time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC),
+				SnoozedUntil: time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+			},
+			"repeating": {
+				NextRunTime: time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC),
+				Pending: &state.PendingOccurrence{
+					Occurrence:   1,
+					NextRepeatAt: time.Date(2024, 1, 15, 8, 30, 0, 0, time.UTC),
+				},
+			},
+			"disabled": {NextRunTime: time.Date(2024, 1, 15, 8, 1, 0, 0, time.UTC)},
+		},
+	}
+
+	want := time.Date(2024, 1, 15, 8, 30, 0, 0, time.UTC)
+	if got := s.NextWakeTime(conf, st, now); !got.Equal(want) {
+		t.Errorf("NextWakeTime() = %v, want %v", got, want)
+	}
+
+	st.Deferred = []state.DeferredDelivery{{SendAfter: time.Date(2024, 1, 15, 8, 15, 0, 0, time.UTC)}}
+	want = time.Date(2024, 1, 15, 8, 15, 0, 0, time.UTC)
+	if got := s.NextWakeTime(conf, st, now); !got.Equal(want) {
+		t.Errorf("NextWakeTime() with deferred delivery = %v, want %v", got, want)
+	}
+
+	conf.Rules = append(conf.Rules, config.Rule{Name: "new", Cron: "0 9 * * *"})
+	if got := s.NextWakeTime(conf, st, now); !got.Equal(now) {
+		t.Errorf("NextWakeTime() with a new rule = %v, want now", got)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/psanford/lambda-reminder/redact"
+	"github.com/psanford/lambda-reminder/simulator"
+	"github.com/psanford/lambda-reminder/state"
+)
+
+// windowList is a repeatable flag of "from/to" RFC 3339 time ranges.
+type windowList []simulator.Window
+
+func (w *windowList) String() string {
+	return fmt.Sprint(*w)
+}
+
+func (w *windowList) Set(s string) error {
+	win, err := parseWindow(s)
+	if err != nil {
+		return err
+	}
+	*w = append(*w, win)
+	return nil
+}
+
+// failureList is a repeatable flag of "destination@from/to" failures.
+type failureList []simulator.Failure
+
+func (f *failureList) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *failureList) Set(s string) error {
+	dest, window, ok := strings.Cut(s, "@")
+	if !ok || dest == "" {
+		return fmt.Errorf("expected destination@from/to, got %q", s)
+	}
+	win, err := parseWindow(window)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, simulator.Failure{Destination: dest, Window: win})
+	return nil
+}
+
+func parseWindow(s string) (simulator.Window, error) {
+	from, to, ok := strings.Cut(s, "/")
+	if !ok {
+		return simulator.Window{}, fmt.Errorf("expected from/to, got %q", s)
+	}
+
+	var (
+		w   simulator.Window
+		err error
+	)
+	w.From, err = time.Parse(time.RFC3339, from)
+	if err != nil {
+		return simulator.Window{}, fmt.Errorf("invalid window start: %w", err)
+	}
+	w.To, err = time.Parse(time.RFC3339, to)
+	if err != nil {
+		return simulator.Window{}, fmt.Errorf("invalid window end: %w", err)
+	}
+	return w, nil
+}
+
+// simulateCommand replays the config over a time range and prints the
+// timeline. State is only read, from -state_path if set, and never saved.
+func (h *handler) simulateCommand(ctx context.Context, w io.Writer, from, to string, tick time.Duration, gaps windowList, failures failureList) error {
+	var (
+		opts simulator.Options
+		err  error
+	)
+	opts.From, err = time.Parse(time.RFC3339, from)
+	if err != nil {
+		return fmt.Errorf("invalid -from, expected RFC 3339: %w", err)
+	}
+	opts.To, err = time.Parse(time.RFC3339, to)
+	if err != nil {
+		return fmt.Errorf("invalid -to, expected RFC 3339: %w", err)
+	}
+	opts.Tick = tick
+	opts.Gaps = gaps
+	opts.Failures = failures
+
+	conf, err := h.configLoader().LoadConfig(ctx, h.s3Client, h.lgr, *configPath)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	h.secrets.Add(conf.Secrets()...)
+
+	var st *state.State
+	if *statePath != "" {
+		st, err = state.LoadState(ctx, h.s3Client, h.lgr, *statePath)
+		if err != nil {
+			return fmt.Errorf("load state: %w", err)
+		}
+	}
+
+	// The scheduler logs every decision; only warnings are useful here
+	quiet := slog.New(redact.NewHandler(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}), h.secrets))
+
+	events, err := simulator.Run(conf, st, opts, quiet)
+	for _, e := range events {
+		fmt.Fprintln(w, e)
+	}
+	return err
+}
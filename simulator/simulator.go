@@ -0,0 +1,242 @@
+// Package simulator replays the scheduler over a time range on a virtual
+// clock, so schedule and state changes can be checked before they reach
+// production.
+package simulator
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/psanford/lambda-reminder/config"
+	"github.com/psanford/lambda-reminder/scheduler"
+	"github.com/psanford/lambda-reminder/state"
+)
+
+// DefaultTick matches the one minute schedule the Lambda is deployed with.
+const DefaultTick = time.Minute
+
+// Window is a half-open time range [From, To).
+type Window struct {
+	From time.Time
+	To   time.Time
+}
+
+func (w Window) Contains(t time.Time) bool {
+	return !t.Before(w.From) && t.Before(w.To)
+}
+
+// Failure makes every send to Destination fail during the window.
+type Failure struct {
+	Destination string
+	Window
+}
+
+type Options struct {
+	From time.Time
+	To   time.Time
+	// Tick is the interval between invocations. Defaults to DefaultTick.
+	Tick time.Duration
+	// Gaps are windows with no invocations, e.g. an outage.
+	Gaps []Window
+	// Failures are windows where sends to a destination fail.
+	Failures []Failure
+}
+
+const (
+	EventFire   = "fire"
+	EventRepeat = "repeat"
+	EventSkip   = "skip"
+	// EventFailed is a fire or repeat whose send failed, so state wasn't
+	// updated and it is retried on the next invocation.
+	EventFailed = "failed"
+)
+
+// Event is one entry in the simulated timeline.
+type Event struct {
+	Time time.Time
+	Kind string
+	Rule string
+	// Scheduled is the run time the occurrence was due at. Time is later
+	// than Scheduled when a gap or failure delayed it.
+	Scheduled    time.Time
+	Occurrence   int
+	Repeat       int
+	Destinations []string
+	// Failed lists the destinations that failed for EventFailed.
+	Failed []string
+}
+
+func (e Event) String() string {
+	s := fmt.Sprintf("%s %-6s %s", e.Time.Format(time.RFC3339), e.Kind, e.Rule)
+	if e.Occurrence > 0 {
+		s += fmt.Sprintf(" occurrence=%d", e.Occurrence)
+	}
+	if e.Repeat > 0 {
+		s += fmt.Sprintf(" repeat=%d", e.Repeat)
+	}
+	if !e.Scheduled.IsZero() && !e.Scheduled.Equal(e.Time) {
+		s += fmt.Sprintf(" scheduled=%s late=%s", e.Scheduled.Format(time.RFC3339), e.Time.Sub(e.Scheduled))
+	}
+	if len(e.Destinations) > 0 {
+		s += fmt.Sprintf(" destinations=%v", e.Destinations)
+	}
+	if len(e.Failed) > 0 {
+		s += fmt.Sprintf(" failed=%v", e.Failed)
+	}
+	return s
+}
+
+// Run simulates invocations from opts.From to opts.To, mutating st as the
+// handler would. A nil st starts from empty state. Sends always succeed
+// unless a failure window covers them; quiet hours aren't simulated.
+func Run(conf *config.Config, st *state.State, opts Options, lgr *slog.Logger) ([]Event, error) {
+	if !opts.To.After(opts.From) {
+		return nil, fmt.Errorf("simulation end must be after its start")
+	}
+
+	tick := opts.Tick
+	if tick == 0 {
+		tick = DefaultTick
+	}
+	if tick < 0 {
+		return nil, fmt.Errorf("tick cannot be negative")
+	}
+
+	if st == nil {
+		st = state.New()
+	}
+
+	loc := time.UTC
+	if conf.Timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(conf.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %s: %w", conf.Timezone, err)
+		}
+	}
+
+	sim := &simulation{
+		conf:  conf,
+		st:    st,
+		opts:  opts,
+		sched: scheduler.New(lgr),
+	}
+
+	for now := opts.From.In(loc); now.Before(opts.To); now = now.Add(tick) {
+		if sim.inGap(now) {
+			continue
+		}
+		err := sim.invoke(now)
+		if err != nil {
+			return sim.events, err
+		}
+	}
+
+	return sim.events, nil
+}
+
+type simulation struct {
+	conf   *config.Config
+	st     *state.State
+	opts   Options
+	sched  *scheduler.Scheduler
+	events []Event
+}
+
+func (s *simulation) inGap(t time.Time) bool {
+	for _, gap := range s.opts.Gaps {
+		if gap.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// invoke mirrors one run of the Lambda handler.
+func (s *simulation) invoke(now time.Time) error {
+	s.sched.ReconcileState(s.conf, s.st, now)
+
+	skips := make(map[string]int)
+	for key, rs := range s.st.Rules {
+		skips[key] = rs.SkipRemaining
+	}
+
+	dueRules, err := s.sched.GetDueRules(s.conf, s.st, now)
+	if err != nil {
+		return fmt.Errorf("get due rules at %s: %w", now, err)
+	}
+
+	for _, rule := range s.conf.Rules {
+		key := rule.StateKey()
+		if skips[key] > s.st.Rules[key].SkipRemaining {
+			s.events = append(s.events, Event{Time: now, Kind: EventSkip, Rule: rule.Name})
+		}
+	}
+
+	for _, rule := range dueRules {
+		rs := s.st.Rules[rule.StateKey()]
+		event := Event{
+			Time:         now,
+			Kind:         EventFire,
+			Rule:         rule.Name,
+			Scheduled:    rs.NextRunTime,
+			Occurrence:   rs.Occurrences + 1,
+			Destinations: rule.Destinations,
+		}
+
+		if event.Failed = s.failed(rule.Destinations, now); len(event.Failed) > 0 {
+			event.Kind = EventFailed
+			s.events = append(s.events, event)
+			continue
+		}
+
+		err = s.sched.UpdateRuleState(s.conf, s.st, rule, now)
+		if err != nil {
+			return fmt.Errorf("update rule state at %s: %w", now, err)
+		}
+		s.events = append(s.events, event)
+	}
+
+	for _, repeat := range s.sched.GetDueRepeats(s.conf, s.st, now) {
+		rule := repeat.Rule
+		dests := repeat.Destinations()
+
+		event := Event{
+			Time:         now,
+			Kind:         EventRepeat,
+			Rule:         rule.Name,
+			Scheduled:    s.st.Rules[rule.StateKey()].Pending.NextRepeatAt,
+			Occurrence:   repeat.Occurrence,
+			Repeat:       repeat.Repeat,
+			Destinations: dests,
+		}
+
+		if event.Failed = s.failed(dests, now); len(event.Failed) > 0 {
+			event.Kind = EventFailed
+			s.events = append(s.events, event)
+			continue
+		}
+
+		err = s.sched.RecordRepeat(s.st, rule, now)
+		if err != nil {
+			return fmt.Errorf("record repeat at %s: %w", now, err)
+		}
+		s.events = append(s.events, event)
+	}
+
+	return nil
+}
+
+func (s *simulation) failed(dests []string, now time.Time) []string {
+	var failed []string
+	for _, dest := range dests {
+		for _, f := range s.opts.Failures {
+			if f.Destination == dest && f.Contains(now) {
+				failed = append(failed, dest)
+				break
+			}
+		}
+	}
+	return failed
+}
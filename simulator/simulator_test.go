@@ -0,0 +1,174 @@
+package simulator
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/psanford/lambda-reminder/config"
+)
+
+func TestRun(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.Config{
+		Timezone: "America/New_York",
+		Rules: []config.Rule{
+			{
+				Name:         "standup",
+				Cron:         "0 9 * * *",
+				Destinations: []string{"pager"},
+			},
+		},
+	}
+
+	opts := Options{
+		From: time.Date(2024, 1, 15, 0, 0, 0, 0, ny),
+		To:   time.Date(2024, 1, 18, 0, 0, 0, 0, ny),
+		Gaps: []Window{
+			{From: time.Date(2024, 1, 16, 8, 0, 0, 0, ny), To: time.Date(2024, 1, 16, 12, 0, 0, 0, ny)},
+		},
+		Failures: []Failure{
+			{Destination: "pager", Window: Window{From: time.Date(2024, 1, 17, 9, 0, 0, 0, ny), To: time.Date(2024, 1, 17, 9, 5, 0, 0, ny)}},
+		},
+	}
+
+	events, err := Run(conf, nil, opts, lgr)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	var fires, failures []Event
+	for _, e := range events {
+		switch e.Kind {
+		case EventFire:
+			fires = append(fires, e)
+		case EventFailed:
+			failures = append(failures, e)
+		}
+	}
+
+	wantFires := []time.Time{
+		time.Date(2024, 1, 15, 9, 0, 0, 0, ny),
+		// Caught up once at the end of the gap
+		time.Date(2024, 1, 16, 12, 0, 0, 0, ny),
+		// Retried every tick until the failure window ended
+		time.Date(2024, 1, 17, 9, 5, 0, 0, ny),
+	}
+	if len(fires) != len(wantFires) {
+		t.Fatalf("Expected %d fires, got %d: %v", len(wantFires), len(fires), fires)
+	}
+	for i, want := range wantFires {
+		if !fires[i].Time.Equal(want) {
+			t.Errorf("Fire %d at %v, want %v", i, fires[i].Time, want)
+		}
+		if fires[i].Occurrence != i+1 {
+			t.Errorf("Fire %d occurrence = %d, want %d", i, fires[i].Occurrence, i+1)
+		}
+	}
+
+	if scheduled := time.Date(2024, 1, 16, 9, 0, 0, 0, ny); !fires[1].Scheduled.Equal(scheduled) {
+		t.Errorf("Expected caught up fire to be scheduled at %v, got %v", scheduled, fires[1].Scheduled)
+	}
+
+	if len(failures) != 5 {
+		t.Errorf("Expected 5 failed attempts, got %d", len(failures))
+	}
+}
+
+func TestRunDST(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.Config{
+		Timezone: "America/New_York",
+		Rules: []config.Rule{
+			{Name: "nightly", Cron: "30 2 * * *"},
+			{Name: "late", Cron: "30 1 * * *"},
+		},
+	}
+
+	cases := []struct {
+		name string
+		from time.Time
+	}{
+		{name: "spring forward", from: time.Date(2024, 3, 9, 0, 0, 0, 0, ny)},
+		{name: "fall back", from: time.Date(2024, 11, 2, 0, 0, 0, 0, ny)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			events, err := Run(conf, nil, Options{From: tc.from, To: tc.from.AddDate(0, 0, 3)}, lgr)
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			perRule := make(map[string]int)
+			for _, e := range events {
+				if e.Kind == EventFire {
+					perRule[e.Rule]++
+				}
+			}
+
+			for _, rule := range conf.Rules {
+				if perRule[rule.Name] != 3 {
+					t.Errorf("Expected %s to fire once a day for 3 days, got %d", rule.Name, perRule[rule.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestRunFinishedRRule(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	conf := &config.Config{
+		Rules: []config.Rule{
+			{
+				Name:         "twice",
+				RRule:        "DTSTART:20240101T090000Z\nRRULE:FREQ=DAILY;COUNT=2",
+				Destinations: []string{"pager"},
+			},
+		},
+	}
+
+	opts := Options{
+		From: time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC),
+	}
+
+	events, err := Run(conf, nil, opts, lgr)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	var fires []time.Time
+	for _, e := range events {
+		if e.Kind == EventFire {
+			fires = append(fires, e.Time)
+		}
+	}
+
+	want := []time.Time{
+		time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC),
+	}
+	if len(fires) != len(want) {
+		t.Fatalf("Expected %d fires, got %d: %v", len(want), len(fires), fires)
+	}
+	for i := range want {
+		if !fires[i].Equal(want[i]) {
+			t.Errorf("Fire %d at %v, want %v", i, fires[i], want[i])
+		}
+	}
+}
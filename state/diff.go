@@ -0,0 +1,105 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Change is a single difference between two states. Path is a dotted path
+// into the JSON form of the state, e.g. "rules.daily.next_run_time". Old or
+// New is nil when the value was added or removed.
+type Change struct {
+	Path string `json:"path"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Path, formatValue(c.Old), formatValue(c.New))
+}
+
+func formatValue(v any) string {
+	if v == nil {
+		return "(none)"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// Clone returns a deep copy of s.
+func (s *State) Clone() (*State, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var c State
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Diff returns the changes from before to after, sorted by path.
+func Diff(before, after *State) ([]Change, error) {
+	a, err := toGeneric(before)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toGeneric(after)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	diffValues("", a, b, &changes)
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+func toGeneric(s *State) (any, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var v any
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
+
+func diffValues(path string, a, b any, changes *[]Change) {
+	am, aIsMap := a.(map[string]any)
+	bm, bIsMap := b.(map[string]any)
+	if aIsMap && bIsMap {
+		for k, av := range am {
+			diffValues(joinPath(path, k), av, bm[k], changes)
+		}
+		for k, bv := range bm {
+			if _, ok := am[k]; !ok {
+				diffValues(joinPath(path, k), nil, bv, changes)
+			}
+		}
+		return
+	}
+
+	aj, _ := json.Marshal(a)
+	bj, _ := json.Marshal(b)
+	if string(aj) != string(bj) {
+		*changes = append(*changes, Change{Path: path, Old: a, New: b})
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
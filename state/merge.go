@@ -0,0 +1,123 @@
+package state
+
+import "encoding/json"
+
+// Merge applies the changes made from base to mine on top of theirs, for
+// when another writer saved theirs after base was loaded. Changes are merged
+// field by field, so a rule fired in mine and acknowledged in theirs keeps
+// both. Where both changed the same value, mine wins. Lists such as
+// State.Deferred are merged as sets.
+func Merge(base, mine, theirs *State) (*State, error) {
+	b, err := toGeneric(base)
+	if err != nil {
+		return nil, err
+	}
+	m, err := toGeneric(mine)
+	if err != nil {
+		return nil, err
+	}
+	t, err := toGeneric(theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(merge3(b, m, t))
+	if err != nil {
+		return nil, err
+	}
+
+	var merged State
+	err = json.Unmarshal(data, &merged)
+	if err != nil {
+		return nil, err
+	}
+	if merged.Rules == nil {
+		merged.Rules = make(map[string]RuleState)
+	}
+	merged.etag = theirs.etag
+	return &merged, nil
+}
+
+// missing marks a key that is absent from an object, as opposed to present
+// with a null value. Keys missing from the merge result are left out.
+type missingValue struct{}
+
+var missing any = missingValue{}
+
+func merge3(base, mine, theirs any) any {
+	if equalValues(mine, base) {
+		return theirs
+	}
+	if equalValues(theirs, base) || equalValues(mine, theirs) {
+		return mine
+	}
+
+	mm, mineIsMap := mine.(map[string]any)
+	tm, theirsIsMap := theirs.(map[string]any)
+	if mineIsMap && theirsIsMap {
+		bm, _ := base.(map[string]any)
+		merged := make(map[string]any)
+		for _, m := range []map[string]any{bm, mm, tm} {
+			for k := range m {
+				v := merge3(lookup(bm, k), lookup(mm, k), lookup(tm, k))
+				if v != missing {
+					merged[k] = v
+				}
+			}
+		}
+		return merged
+	}
+
+	ml, mineIsList := mine.([]any)
+	tl, theirsIsList := theirs.([]any)
+	if mineIsList && theirsIsList {
+		bl, _ := base.([]any)
+		return mergeSets(bl, ml, tl)
+	}
+
+	return mine
+}
+
+func lookup(m map[string]any, k string) any {
+	v, ok := m[k]
+	if !ok {
+		return missing
+	}
+	return v
+}
+
+// mergeSets returns theirs without the items mine removed from base and with
+// the items mine added.
+func mergeSets(base, mine, theirs []any) []any {
+	merged := []any{}
+	for _, v := range theirs {
+		if contains(base, v) && !contains(mine, v) {
+			continue
+		}
+		merged = append(merged, v)
+	}
+	for _, v := range mine {
+		if !contains(base, v) && !contains(merged, v) {
+			merged = append(merged, v)
+		}
+	}
+	return merged
+}
+
+func contains(list []any, v any) bool {
+	for _, item := range list {
+		if equalValues(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalValues(a, b any) bool {
+	if a == missing || b == missing {
+		return a == b
+	}
+	aj, _ := json.Marshal(a)
+	bj, _ := json.Marshal(b)
+	return string(aj) == string(bj)
+}
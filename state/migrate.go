@@ -0,0 +1,93 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// CurrentVersion is the state schema version written by this build.
+// Bump it whenever the serialized form of State or RuleState changes, so an
+// older build refuses to load, and then overwrite without the new fields,
+// state written by a newer one. Add an entry to migrations if existing
+// documents need rewriting for the new version.
+//
+// Versions 2 through 9 only added fields whose zero value is right for older
+// documents, so none of them needs a migration:
+//
+//	2: State.Version
+//	3: RuleState.Inactive
+//	4: RuleState.Occurrences, counting from zero for rules fired before v4
+//	5: State.Deferred
+//	6: RuleState.Pending and RuleState.AcknowledgedAt
+//	7: RuleState.SkipRemaining and RuleState.SnoozedUntil
+//	8: RuleState.ScheduleHash, recorded without recalculating for rules
+//	   stored before v8
+//	9: RuleState.Finished
+const CurrentVersion = 9
+
+// legacyVersion is assumed for documents written before the version field
+// existed.
+const legacyVersion = 1
+
+// migration upgrades a raw state document from one version to the next.
+type migration func(doc map[string]json.RawMessage) error
+
+// migrations is keyed by the version a migration upgrades from. Versions
+// without an entry upgrade as is.
+var migrations = map[int]migration{}
+
+func decodeState(r io.Reader, lgr *slog.Logger) (*State, error) {
+	var doc map[string]json.RawMessage
+	err := json.NewDecoder(r).Decode(&doc)
+	if err == io.EOF || (err == nil && len(doc) == 0) {
+		// An empty file, null or {}
+		lgr.Info("state document is empty, starting with empty state")
+		return New(), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	version := legacyVersion
+	if raw, ok := doc["version"]; ok {
+		err = json.Unmarshal(raw, &version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version: %w", err)
+		}
+	}
+
+	if version > CurrentVersion {
+		return nil, fmt.Errorf("state version %d is newer than supported version %d", version, CurrentVersion)
+	}
+
+	for v := version; v < CurrentVersion; v++ {
+		migrate, ok := migrations[v]
+		if !ok {
+			continue
+		}
+		err = migrate(doc)
+		if err != nil {
+			return nil, fmt.Errorf("migrate state from version %d: %w", v, err)
+		}
+	}
+
+	if version != CurrentVersion {
+		lgr.Info("migrated state", "from_version", version, "to_version", CurrentVersion)
+	}
+
+	doc["version"] = json.RawMessage(fmt.Sprint(CurrentVersion))
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var state State
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
@@ -3,15 +3,20 @@ package state
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 )
 
 type RuleState struct {
@@ -19,10 +24,116 @@ type RuleState struct {
 	CronExpr    string    `json:"cron_expr"`
 	LastRunTime time.Time `json:"last_run_time"`
 	NextRunTime time.Time `json:"next_run_time"`
+
+	// ScheduleHash is the rule's config.Rule.ScheduleFingerprint when
+	// NextRunTime was calculated.
+	ScheduleHash string `json:"schedule_hash,omitempty"`
+
+	// OrphanedAt is when the rule was first seen missing from the config.
+	// It is cleared if the rule reappears before the grace period ends.
+	OrphanedAt time.Time `json:"orphaned_at,omitzero"`
+
+	// Inactive is set while the rule is disabled or outside its start and
+	// end dates, so the next run time can be recalculated when it becomes
+	// active again.
+	Inactive bool `json:"inactive,omitempty"`
+
+	// Occurrences is the number of times the rule has fired.
+	Occurrences int `json:"occurrences,omitempty"`
+	// Finished is set once the rule's schedule has no more occurrences,
+	// such as an RRULE past its COUNT or UNTIL. NextRunTime is zero.
+	Finished bool `json:"finished,omitempty"`
+
+	// Pending is the most recent occurrence until it is acknowledged.
+	Pending *PendingOccurrence `json:"pending,omitempty"`
+	// AcknowledgedAt is when an occurrence was last acknowledged.
+	AcknowledgedAt time.Time `json:"acknowledged_at,omitzero"`
+
+	// SkipRemaining is the number of upcoming occurrences to skip without
+	// sending.
+	SkipRemaining int `json:"skip_remaining,omitempty"`
+	// SnoozedUntil holds back the next occurrence until this time.
+	SnoozedUntil time.Time `json:"snoozed_until,omitzero"`
+}
+
+// PendingOccurrence tracks a fired occurrence that hasn't been acknowledged.
+type PendingOccurrence struct {
+	Occurrence  int       `json:"occurrence"`
+	FirstSentAt time.Time `json:"first_sent_at"`
+	LastSentAt  time.Time `json:"last_sent_at"`
+	Repeats     int       `json:"repeats,omitempty"`
+	// NextRepeatAt is when the occurrence will be re-sent, zero if it won't.
+	NextRepeatAt time.Time `json:"next_repeat_at,omitzero"`
 }
 
 type State struct {
-	Rules map[string]RuleState `json:"rules"`
+	// Version is the schema version of the state document. See migrate.go.
+	Version int                  `json:"version"`
+	Rules   map[string]RuleState `json:"rules"`
+
+	// Archived holds state for rules removed from the config when the
+	// orphan action is "archive".
+	Archived map[string]RuleState `json:"archived,omitempty"`
+
+	// Deferred holds notifications held back by destination quiet hours.
+	Deferred []DeferredDelivery `json:"deferred,omitempty"`
+
+	// etag identifies the stored state this was loaded from, blank if there
+	// was none. SaveState only overwrites that same version.
+	etag string
+}
+
+// ErrConflict is returned by SaveState when the stored state was changed
+// since it was loaded. Reload it and apply the change again, or Merge.
+var ErrConflict = errors.New("state was modified since it was loaded")
+
+// DeferredDelivery is a rendered notification waiting for a destination's
+// quiet hours to end.
+type DeferredDelivery struct {
+	RuleKey       string    `json:"rule_key"`
+	RuleName      string    `json:"rule_name"`
+	Occurrence    int       `json:"occurrence"`
+	DestinationID string    `json:"destination_id"`
+	Schedule      string    `json:"schedule"`
+	Subject       string    `json:"subject"`
+	Body          string    `json:"body"`
+	DeferredAt    time.Time `json:"deferred_at"`
+	SendAfter     time.Time `json:"send_after"`
+}
+
+// AddDeferred records a deferred delivery unless the same occurrence is
+// already deferred for the destination, which happens when a rule is retried
+// after other destinations failed.
+func (s *State) AddDeferred(d DeferredDelivery) bool {
+	for _, existing := range s.Deferred {
+		if existing.RuleKey == d.RuleKey && existing.DestinationID == d.DestinationID && existing.Occurrence == d.Occurrence {
+			return false
+		}
+	}
+	s.Deferred = append(s.Deferred, d)
+	return true
+}
+
+func New() *State {
+	return &State{
+		Version: CurrentVersion,
+		Rules:   make(map[string]RuleState),
+	}
+}
+
+// ResetRule forgets everything stored for a rule: its state, any archived
+// state and its deferred deliveries. The rule starts fresh on the next run.
+func (s *State) ResetRule(key string) {
+	delete(s.Rules, key)
+	delete(s.Archived, key)
+
+	var remaining []DeferredDelivery
+	for _, d := range s.Deferred {
+		if d.RuleKey != key {
+			remaining = append(remaining, d)
+		}
+	}
+	s.Deferred = remaining
 }
 
 func getStateLocation() (bucket, key string, err error) {
@@ -41,24 +152,30 @@ func getStateLocation() (bucket, key string, err error) {
 }
 
 func LoadState(ctx context.Context, s3Client *s3.Client, lgr *slog.Logger, localStatePath string) (*State, error) {
-	var state State
+	var state *State
 
 	if localStatePath != "" {
 		f, err := os.Open(localStatePath)
 
 		if errors.Is(err, os.ErrNotExist) {
 			lgr.Info("state file does not exist, starting with empty state")
-			return &State{Rules: make(map[string]RuleState)}, nil
+			return New(), nil
 		} else if err != nil {
 			return nil, fmt.Errorf("load local state file err %w", err)
 		}
 
 		defer f.Close()
 
-		err = json.NewDecoder(f).Decode(&state)
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return nil, fmt.Errorf("read local state file: %w", err)
+		}
+
+		state, err = decodeState(bytes.NewReader(data), lgr)
 		if err != nil {
 			return nil, fmt.Errorf("decode state: %w", err)
 		}
+		state.etag = contentTag(data)
 	} else {
 		bucket, key, err := getStateLocation()
 		if err != nil {
@@ -73,36 +190,54 @@ func LoadState(ctx context.Context, s3Client *s3.Client, lgr *slog.Logger, local
 			var apiErr smithy.APIError
 			if ok := errors.As(err, &apiErr); ok && apiErr.ErrorCode() == "NoSuchKey" {
 				lgr.Info("state file does not exist, starting with empty state")
-				return &State{Rules: make(map[string]RuleState)}, nil
+				return New(), nil
 			}
 			return nil, fmt.Errorf("get state from s3: %w", err)
 		}
 		defer result.Body.Close()
 
-		err = json.NewDecoder(result.Body).Decode(&state)
+		state, err = decodeState(result.Body, lgr)
 		if err != nil {
 			return nil, fmt.Errorf("decode state: %w", err)
 		}
+		state.etag = aws.ToString(result.ETag)
 	}
 
 	if state.Rules == nil {
 		state.Rules = make(map[string]RuleState)
 	}
 
-	return &state, nil
+	return state, nil
 }
 
+// SaveState writes state, failing with ErrConflict if the stored state was
+// changed since state was loaded. In S3 this is a conditional put; a local
+// file is checked just before writing.
 func SaveState(ctx context.Context, s3Client *s3.Client, state *State, lgr *slog.Logger, localStatePath string) error {
+	state.Version = CurrentVersion
+
 	data, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
 		return fmt.Errorf("marshal state: %w", err)
 	}
 
 	if localStatePath != "" {
-		err := os.WriteFile(localStatePath, data, 0600)
+		var currentTag string
+		current, err := os.ReadFile(localStatePath)
+		if err == nil {
+			currentTag = contentTag(current)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("read local state file: %w", err)
+		}
+		if currentTag != state.etag {
+			return ErrConflict
+		}
+
+		err = os.WriteFile(localStatePath, data, 0600)
 		if err != nil {
 			return fmt.Errorf("create local state file: %w", err)
 		}
+		state.etag = contentTag(data)
 	} else {
 
 		bucket, key, err := getStateLocation()
@@ -110,15 +245,35 @@ func SaveState(ctx context.Context, s3Client *s3.Client, state *State, lgr *slog
 			return err
 		}
 
-		_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		// Only replace the version that was loaded, or create the object if
+		// there was none
+		condition := smithyhttp.AddHeaderValue("If-None-Match", "*")
+		if state.etag != "" {
+			condition = smithyhttp.AddHeaderValue("If-Match", state.etag)
+		}
+
+		out, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
 			Bucket: &bucket,
 			Key:    &key,
 			Body:   bytes.NewReader(data),
+		}, func(o *s3.Options) {
+			o.APIOptions = append(o.APIOptions, condition)
 		})
 		if err != nil {
+			var apiErr smithy.APIError
+			if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict") {
+				return ErrConflict
+			}
 			return fmt.Errorf("put state to s3: %w", err)
 		}
+		state.etag = aws.ToString(out.ETag)
 	}
 
 	return nil
 }
+
+// contentTag identifies the content of a local state file.
+func contentTag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,309 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadLegacyState(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "rules_state.json")
+	legacy := `{
+  "rules": {
+    "daily": {
+      "name": "daily",
+      "cron_expr": "0 9 * * *",
+      "last_run_time": "2024-01-15T09:00:00Z",
+      "next_run_time": "2024-01-16T09:00:00Z"
+    }
+  }
+}`
+	err := os.WriteFile(path, []byte(legacy), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := LoadState(ctx, nil, lgr, path)
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+
+	if st.Version != CurrentVersion {
+		t.Errorf("Expected version %d after migration, got %d", CurrentVersion, st.Version)
+	}
+
+	rs, exists := st.Rules["daily"]
+	if !exists {
+		t.Fatal("Expected daily rule state to be loaded")
+	}
+
+	expected := time.Date(2024, 1, 16, 9, 0, 0, 0, time.UTC)
+	if !rs.NextRunTime.Equal(expected) {
+		t.Errorf("Expected next run time %v, got %v", expected, rs.NextRunTime)
+	}
+}
+
+func TestLoadNewerStateVersion(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "rules_state.json")
+	err := os.WriteFile(path, []byte(`{"version": 999, "rules": {}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadState(ctx, nil, lgr, path)
+	if err == nil {
+		t.Fatal("Expected error loading state from a newer version")
+	}
+	if !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Expected newer version error, got %v", err)
+	}
+}
+
+func TestLoadEmptyState(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	ctx := context.Background()
+
+	for _, doc := range []string{"", "null", "{}", " null\n"} {
+		path := filepath.Join(t.TempDir(), "rules_state.json")
+		err := os.WriteFile(path, []byte(doc), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		st, err := LoadState(ctx, nil, lgr, path)
+		if err != nil {
+			t.Fatalf("LoadState(%q) error = %v", doc, err)
+		}
+		if st.Version != CurrentVersion {
+			t.Errorf("Expected version %d for %q, got %d", CurrentVersion, doc, st.Version)
+		}
+		if st.Rules == nil || len(st.Rules) != 0 {
+			t.Errorf("Expected empty rules for %q, got %v", doc, st.Rules)
+		}
+	}
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "rules_state.json")
+
+	st, err := LoadState(ctx, nil, lgr, path)
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if len(st.Rules) != 0 {
+		t.Errorf("Expected empty state for missing file, got %d rules", len(st.Rules))
+	}
+
+	next := time.Date(2024, 1, 16, 9, 0, 0, 0, time.UTC)
+	st.Rules["daily"] = RuleState{
+		Name:        "daily",
+		CronExpr:    "0 9 * * *",
+		NextRunTime: next,
+	}
+
+	err = SaveState(ctx, nil, st, lgr, path)
+	if err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+
+	loaded, err := LoadState(ctx, nil, lgr, path)
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+
+	if loaded.Version != CurrentVersion {
+		t.Errorf("Expected version %d, got %d", CurrentVersion, loaded.Version)
+	}
+	if !loaded.Rules["daily"].NextRunTime.Equal(next) {
+		t.Errorf("Expected next run time %v, got %v", next, loaded.Rules["daily"].NextRunTime)
+	}
+}
+
+func TestAddDeferred(t *testing.T) {
+	st := New()
+
+	d := DeferredDelivery{
+		RuleKey:       "daily",
+		RuleName:      "daily",
+		Occurrence:    3,
+		DestinationID: "pager",
+		SendAfter:     time.Date(2024, 1, 16, 7, 0, 0, 0, time.UTC),
+	}
+
+	if !st.AddDeferred(d) {
+		t.Error("Expected first deferral to be added")
+	}
+	if st.AddDeferred(d) {
+		t.Error("Expected retried deferral of the same occurrence to be ignored")
+	}
+
+	d.Occurrence = 4
+	if !st.AddDeferred(d) {
+		t.Error("Expected deferral of the next occurrence to be added")
+	}
+
+	if len(st.Deferred) != 2 {
+		t.Errorf("Expected 2 deferred deliveries, got %d", len(st.Deferred))
+	}
+}
+
+func TestResetRule(t *testing.T) {
+	st := New()
+	st.Rules["daily"] = RuleState{Name: "daily", Occurrences: 4}
+	st.Rules["weekly"] = RuleState{Name: "weekly"}
+	st.AddDeferred(DeferredDelivery{RuleKey: "daily", DestinationID: "pager", Occurrence: 4})
+	st.AddDeferred(DeferredDelivery{RuleKey: "weekly", DestinationID: "pager", Occurrence: 1})
+
+	st.ResetRule("daily")
+
+	if _, exists := st.Rules["daily"]; exists {
+		t.Error("Expected daily rule state to be removed")
+	}
+	if _, exists := st.Rules["weekly"]; !exists {
+		t.Error("Expected weekly rule state to be kept")
+	}
+	if len(st.Deferred) != 1 || st.Deferred[0].RuleKey != "weekly" {
+		t.Errorf("Expected only weekly's deferred delivery to be kept, got %+v", st.Deferred)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := New()
+	before.Rules["daily"] = RuleState{
+		Name:        "daily",
+		CronExpr:    "0 9 * * *",
+		NextRunTime: time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC),
+	}
+	before.Rules["old"] = RuleState{Name: "old"}
+
+	after, err := before.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := after.Rules["daily"]
+	rs.LastRunTime = time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
+	rs.NextRunTime = time.Date(2024, 1, 16, 9, 0, 0, 0, time.UTC)
+	rs.Occurrences = 1
+	after.Rules["daily"] = rs
+	delete(after.Rules, "old")
+
+	changes, err := Diff(before, after)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	want := []string{
+		`rules.daily.last_run_time: "0001-01-01T00:00:00Z" -> "2024-01-15T09:00:00Z"`,
+		`rules.daily.next_run_time: "2024-01-15T09:00:00Z" -> "2024-01-16T09:00:00Z"`,
+		`rules.daily.occurrences: (none) -> 1`,
+		`rules.old: {"cron_expr":"","last_run_time":"0001-01-01T00:00:00Z","name":"old","next_run_time":"0001-01-01T00:00:00Z"} -> (none)`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Diff() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if before.Rules["daily"].Occurrences != 0 {
+		t.Error("Expected Clone to return an independent copy")
+	}
+}
+
+func TestSaveStateConflict(t *testing.T) {
+	lgr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "rules_state.json")
+
+	st, err := LoadState(ctx, nil, lgr, path)
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	other, err := LoadState(ctx, nil, lgr, path)
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+
+	st.Rules["daily"] = RuleState{Name: "daily", Occurrences: 1}
+	err = SaveState(ctx, nil, st, lgr, path)
+	if err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+
+	other.Rules["weekly"] = RuleState{Name: "weekly"}
+	err = SaveState(ctx, nil, other, lgr, path)
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("Expected ErrConflict saving over a newer state, got %v", err)
+	}
+
+	// A saved state can be saved again
+	st.Rules["daily"] = RuleState{Name: "daily", Occurrences: 2}
+	err = SaveState(ctx, nil, st, lgr, path)
+	if err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	fired := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
+
+	base := New()
+	base.Rules["standup"] = RuleState{Name: "standup", Occurrences: 1}
+	base.Rules["retro"] = RuleState{
+		Name:        "retro",
+		Occurrences: 3,
+		Pending:     &PendingOccurrence{Occurrence: 3, FirstSentAt: fired},
+	}
+	base.AddDeferred(DeferredDelivery{RuleKey: "standup", DestinationID: "pager", Occurrence: 1})
+
+	// This run fired standup, sent its deferred delivery and deferred the
+	// new occurrence
+	mine, _ := base.Clone()
+	mine.Rules["standup"] = RuleState{Name: "standup", Occurrences: 2, LastRunTime: fired}
+	mine.Deferred = nil
+	mine.AddDeferred(DeferredDelivery{RuleKey: "standup", DestinationID: "pager", Occurrence: 2})
+
+	// Meanwhile retro was acknowledged and a rule was skipped
+	theirs, _ := base.Clone()
+	retro := theirs.Rules["retro"]
+	retro.Pending = nil
+	retro.AcknowledgedAt = fired
+	theirs.Rules["retro"] = retro
+	theirs.Rules["standup"] = RuleState{Name: "standup", Occurrences: 1, SkipRemaining: 2}
+	theirs.etag = "theirs"
+
+	merged, err := Merge(base, mine, theirs)
+	if err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+
+	if rs := merged.Rules["retro"]; rs.Pending != nil || !rs.AcknowledgedAt.Equal(fired) {
+		t.Errorf("Expected the acknowledgement to be kept, got %+v", rs)
+	}
+	standup := merged.Rules["standup"]
+	if standup.Occurrences != 2 || !standup.LastRunTime.Equal(fired) || standup.SkipRemaining != 2 {
+		t.Errorf("Expected the fire and the skip to both be kept, got %+v", standup)
+	}
+	if len(merged.Deferred) != 1 || merged.Deferred[0].Occurrence != 2 {
+		t.Errorf("Expected only the new deferred delivery, got %+v", merged.Deferred)
+	}
+	if merged.etag != "theirs" {
+		t.Errorf("Expected the merged state to replace theirs, got etag %q", merged.etag)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/psanford/lambda-reminder/config"
+)
+
+// reloadDebounce coalesces the burst of events editors produce when saving.
+const reloadDebounce = 100 * time.Millisecond
+
+// watchConfig reloads the local config whenever it changes until ctx is
+// done. Directories are watched rather than files so editors that save by
+// renaming a new file into place are handled. If the config is a directory
+// any config file added to it triggers a reload, and the directories of
+// included files are watched as they're discovered.
+func (h *handler) watchConfig(ctx context.Context) error {
+	root, err := filepath.Abs(*configPath)
+	if err != nil {
+		return err
+	}
+
+	rootDir := filepath.Dir(root)
+	if fi, err := os.Stat(root); err == nil && fi.IsDir() {
+		rootDir = root
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("create watcher: %w", err)
+	}
+
+	err = watcher.Add(rootDir)
+	if err != nil {
+		watcher.Close()
+		return fmt.Errorf("watch %s: %w", rootDir, err)
+	}
+
+	// Load the config now so included files are watched from the start.
+	// Errors are reported by the first run.
+	h.loadConfig(ctx)
+	h.watchIncludes(watcher, rootDir)
+
+	h.confMu.Lock()
+	h.watchingConfig = true
+	if h.configReloaded == nil {
+		h.configReloaded = make(chan struct{}, 1)
+	}
+	h.confMu.Unlock()
+
+	go func() {
+		defer watcher.Close()
+
+		var debounce *time.Timer
+		for {
+			select {
+			case <-ctx.Done():
+				if debounce != nil {
+					debounce.Stop()
+				}
+				return
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !h.isConfigFile(ev.Name, root, rootDir) || !ev.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) {
+					continue
+				}
+				if debounce != nil {
+					debounce.Stop()
+				}
+				debounce = time.AfterFunc(reloadDebounce, func() {
+					h.reloadConfig(ctx)
+					h.watchIncludes(watcher, rootDir)
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				h.lgr.Warn("config watcher error", "err", err)
+			}
+		}
+	}()
+
+	h.lgr.Info("watching config for changes", "path", root)
+	return nil
+}
+
+// isConfigFile reports whether name is part of the config: the config
+// file itself, a config file in the config directory or in the directory
+// of an included file, or a file the current config was loaded from.
+func (h *handler) isConfigFile(name, root, rootDir string) bool {
+	name = filepath.Clean(name)
+	if name == root {
+		return true
+	}
+	if _, ok := config.FormatFromPath(name); ok && (root == rootDir || filepath.Dir(name) != rootDir) {
+		// Only the root and include directories are watched
+		return true
+	}
+
+	h.confMu.Lock()
+	defer h.confMu.Unlock()
+	if h.conf == nil {
+		return false
+	}
+	for _, f := range h.conf.Files() {
+		if abs, err := filepath.Abs(f); err == nil && abs == name {
+			return true
+		}
+	}
+	return false
+}
+
+// watchIncludes watches the directories of the files the current config
+// was loaded from. Adding a directory that is already watched is a no-op.
+func (h *handler) watchIncludes(watcher *fsnotify.Watcher, rootDir string) {
+	h.confMu.Lock()
+	var files []string
+	if h.conf != nil {
+		files = h.conf.Files()
+	}
+	h.confMu.Unlock()
+
+	for _, f := range files {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			continue
+		}
+		dir := filepath.Dir(abs)
+		if dir == rootDir {
+			continue
+		}
+		err = watcher.Add(dir)
+		if err != nil {
+			h.lgr.Warn("watch included config dir", "dir", dir, "err", err)
+		}
+	}
+}